@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+
+	"github.com/iwqos22-autoscale/code/extractor"
+)
+
+// Offline analysis of traces exported from the Jaeger UI (JSON) or from Zipkin (v2 JSON):
+// prints the bottleneck pod, the p50/p99 latency of every entry operation and request class, and the self time
+// of every service.
+
+// pods printed of the tail latency attribution
+const numBlamedPods = 5
+
+var (
+	file       string
+	dotFile    string
+	jsonFile   string
+	traceID    string
+	podTagKeys string
+	// options of the analyses, built from the flags
+	options extractor.AnalysisOptions
+)
+
+func main() {
+	flag.StringVar(&file, "file", "", "jaeger json or zipkin v2 json file of traces")
+	flag.StringVar(&dotFile, "dot", "", "write the graph in Graphviz DOT to this file")
+	flag.StringVar(&jsonFile, "json", "", "write the graph in JSON to this file")
+	flag.StringVar(&traceID, "trace", "", "export the graph of this trace instead of the service graph of all traces")
+	flag.StringVar(&podTagKeys, "pod-tag-keys", strings.Join(extractor.DefaultPodTagKeys, ","), "span tags identifying the pod, tried in order")
+	flag.Parse()
+
+	if file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	options.PodIdentifier = extractor.NewPodIdentifier(strings.Split(podTagKeys, ","), nil)
+
+	reader, err := extractor.NewFileReader(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer reader.Close()
+
+	traces := reader.AllTraces()
+	fmt.Printf("traces: %d\n", len(traces))
+	if len(traces) == 0 {
+		return
+	}
+	if err := export(traces); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// entry operations are the ones of the root spans, only those with a latency have percentiles
+	incomplete, unresolved := 0, 0
+	opSet := make(map[string]struct{})
+	for _, trace := range traces {
+		graph := extractor.NewGraph(trace, options)
+		completeness := graph.GetCompleteness()
+		if !completeness.Complete() {
+			incomplete++
+		}
+		unresolved += completeness.UnresolvedPods
+		if root := graph.GetRoot(); root != nil && !root.IsSynthetic() && root.GetDuration() != 0 {
+			opSet[root.GetOperationName()] = struct{}{}
+		}
+	}
+	fmt.Printf("incomplete traces: %d\n", incomplete)
+	fmt.Printf("spans of unresolved pods: %d\n", unresolved)
+
+	fmt.Printf("bottleneck pod: %s\n", extractor.ExtractBottleNeckPod(traces, options))
+	fmt.Printf("bottleneck pod by self time: %s\n", extractor.ExtractBottleNeckPodBySelfTime(traces, options))
+	for i, blame := range extractor.AttributeTailLatency(traces, extractor.DefaultTailQuantile, options) {
+		if i == numBlamedPods {
+			break
+		}
+		fmt.Printf("tail latency of %s\textra: %.3fms\ttail: %.3fms\tmedian: %.3fms\n", blame.Pod, blame.ExtraMs, blame.TailMs, blame.MedianMs)
+	}
+
+	opNames := make([]string, 0, len(opSet))
+	for opName := range opSet {
+		opNames = append(opNames, opName)
+	}
+	sort.Strings(opNames)
+
+	latencies := extractor.GetPercentileLatencyByOperation([]float64{0.5, 0.99}, traces, opNames, options)
+	for _, opName := range opNames {
+		fmt.Printf("%s\tp50: %v\tp99: %v\n", opName, latencies[opName][0], latencies[opName][1])
+	}
+
+	for _, class := range extractor.ClassifyTraces(traces, options) {
+		fmt.Printf("class %s of %s\ttraces: %d\tp50: %v\tp99: %v\n", class.ID, class.RootOperation, class.Count,
+			class.Latency.Quantile(0.5), class.Latency.Quantile(0.99))
+		for _, step := range class.GetCriticalPath() {
+			fmt.Printf("\t%s\tcritical p50: %v\n", step.Node, step.Contribution.Quantile(0.5))
+		}
+	}
+
+	selfTimes := extractor.GetSelfTimeDistributionByService(traces, options)
+	services := make([]string, 0, len(selfTimes))
+	for service := range selfTimes {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		fmt.Printf("%s\tself time p50: %v\tp99: %v\n", service, selfTimes[service].Quantile(0.5), selfTimes[service].Quantile(0.99))
+	}
+}
+
+type exporter interface {
+	WriteDOT(w io.Writer) error
+	WriteJSON(w io.Writer) error
+}
+
+func export(traces []*model.Trace) error {
+	if dotFile == "" && jsonFile == "" {
+		return nil
+	}
+
+	var graph exporter
+	if traceID != "" {
+		id, err := model.TraceIDFromString(traceID)
+		if err != nil {
+			return err
+		}
+		for _, trace := range traces {
+			if len(trace.Spans) > 0 && trace.Spans[0].TraceID == id {
+				graph = extractor.NewGraph(trace, options)
+				break
+			}
+		}
+		if graph == nil {
+			return fmt.Errorf("trace %s not found", traceID)
+		}
+	} else {
+		graph = extractor.BuildServiceGraph(traces, options)
+	}
+
+	for _, out := range []struct {
+		path  string
+		write func(w io.Writer) error
+	}{
+		{dotFile, graph.WriteDOT},
+		{jsonFile, graph.WriteJSON},
+	} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return err
+		}
+		if err := out.write(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package extractor
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	DefaultSignificance    = 0.01
+	DefaultBaselineWindows = 12
+)
+
+// AnomalyDetector keeps the self times of each service over the last windows as a baseline, and tests the
+// self times of every new window against it with a Mann-Whitney U test. The test ranks the samples, so it needs
+// no model of the latency and is not thrown off by outliers; the samples are not weighted for sampling.
+type AnomalyDetector struct {
+	mu           sync.Mutex
+	numWindows   int
+	significance float64
+	minSamples   int
+	options      AnalysisOptions
+	// self times of the last windows by service, oldest first
+	windows []map[string][]time.Duration
+}
+
+// ServiceAnomaly is a service whose self times in the window differ from its baseline
+type ServiceAnomaly struct {
+	Service string
+	// PValue of the test, after the correction for the number of services tested
+	PValue float64
+	// Effect is the probability that a self time of the window exceeds one of the baseline, 0.5 if they are alike
+	Effect float64
+	// Shift is the median of the window minus the median of the baseline, positive if the service got slower
+	Shift           time.Duration
+	Samples         int
+	BaselineSamples int
+}
+
+// NewAnomalyDetector keeps numWindows windows in the baseline, and flags a service when the self times of a window
+// differ from the baseline at significance, with at least minSamples of them on both sides
+func NewAnomalyDetector(numWindows int, significance float64, minSamples int, options AnalysisOptions) *AnomalyDetector {
+	if minSamples < 1 {
+		minSamples = 1
+	}
+	return &AnomalyDetector{
+		numWindows:   numWindows,
+		significance: significance,
+		minSamples:   minSamples,
+		options:      options,
+	}
+}
+
+// Observe tests the self times of the services in traces, a window, against the baseline and then adds them to it,
+// so a lasting change becomes the baseline after numWindows. The anomalies are returned, most significant first.
+// The significance is split over the services tested (Bonferroni), not to raise an alarm in every window.
+func (d *AnomalyDetector) Observe(traces []*model.Trace) []ServiceAnomaly {
+	window := make(map[string][]time.Duration)
+	for _, trace := range traces {
+		for service, selfTime := range NewGraph(trace, d.options).GetSelfTimeByService() {
+			window[service] = append(window[service], selfTime)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	baselines := make(map[string][]time.Duration)
+	for _, w := range d.windows {
+		for service, selfTimes := range w {
+			baselines[service] = append(baselines[service], selfTimes...)
+		}
+	}
+	tested := make([]ServiceAnomaly, 0, len(window))
+	for service, selfTimes := range window {
+		baseline := baselines[service]
+		if len(selfTimes) < d.minSamples || len(baseline) < d.minSamples {
+			continue
+		}
+		pValue, effect := mannWhitney(selfTimes, baseline)
+		tested = append(tested, ServiceAnomaly{
+			Service:         service,
+			PValue:          pValue,
+			Effect:          effect,
+			Shift:           medianOf(selfTimes) - medianOf(baseline),
+			Samples:         len(selfTimes),
+			BaselineSamples: len(baseline),
+		})
+	}
+
+	d.windows = append(d.windows, window)
+	if len(d.windows) > d.numWindows {
+		d.windows = d.windows[len(d.windows)-d.numWindows:]
+	}
+
+	anomalies := make([]ServiceAnomaly, 0)
+	for _, a := range tested {
+		a.PValue = math.Min(1, a.PValue*float64(len(tested)))
+		if a.PValue < d.significance {
+			anomalies = append(anomalies, a)
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].PValue != anomalies[j].PValue {
+			return anomalies[i].PValue < anomalies[j].PValue
+		}
+		return anomalies[i].Service < anomalies[j].Service
+	})
+	return anomalies
+}
+
+// mannWhitney returns the two-sided p-value of the U test of x against y, from the normal approximation with
+// the tie and continuity corrections, and the probability that a value of x exceeds one of y
+func mannWhitney(x, y []time.Duration) (float64, float64) {
+	type sample struct {
+		value time.Duration
+		fromX bool
+	}
+	samples := make([]sample, 0, len(x)+len(y))
+	for _, v := range x {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range y {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].value < samples[j].value
+	})
+
+	// ranks start at 1, tied values share the mean of their ranks
+	var rankSumX, ties float64
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+1+j) / 2
+		for k := i; k < j; k++ {
+			if samples[k].fromX {
+				rankSumX += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(x)), float64(len(y))
+	n := n1 + n2
+	u := rankSumX - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	effect := u / (n1 * n2)
+	if variance <= 0 {
+		// every value is the same
+		return 1, effect
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2), effect
+}
+
+func medianOf(values []time.Duration) time.Duration {
+	sorted := make([]time.Duration, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,106 @@
+package extractor
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestMannWhitney(t *testing.T) {
+	ms := func(values ...float64) []time.Duration {
+		durations := make([]time.Duration, 0, len(values))
+		for _, v := range values {
+			durations = append(durations, time.Duration(v*float64(time.Millisecond)))
+		}
+		return durations
+	}
+	// p-values of the normal approximation with the tie and continuity corrections, from U counted pair by pair,
+	// like R's wilcox.test(x, y, exact = FALSE)
+	tests := []struct {
+		name   string
+		x, y   []time.Duration
+		pValue float64
+		effect float64
+	}{
+		{"separated", ms(1, 2, 3, 4, 5), ms(6, 7, 8, 9, 10), 0.012185780355344818, 0},
+		{"ties", ms(1, 2, 2, 3, 3, 3), ms(2, 3, 4, 4, 5), 0.08871369199677624, 0.18333333333333332},
+		{"interleaved", ms(1, 3, 5, 7), ms(2, 4, 6, 8), 0.6650055421020291, 0.375},
+		// the example of wilcox.test, W = 35
+		{"wilcox.test", ms(0.80, 0.83, 1.89, 1.04, 1.45, 1.38, 1.91, 1.64, 0.73, 1.46), ms(1.15, 0.88, 0.90, 0.74, 1.21),
+			0.24462360512698333, 0.7},
+		{"all the same", ms(4, 4, 4), ms(4, 4), 1, 0.5},
+	}
+	for _, tt := range tests {
+		pValue, effect := mannWhitney(tt.x, tt.y)
+		if math.Abs(pValue-tt.pValue) > 1e-9 || math.Abs(effect-tt.effect) > 1e-9 {
+			t.Errorf("%s: p-value %v and effect %v, want %v and %v", tt.name, pValue, effect, tt.pValue, tt.effect)
+		}
+		// the test is symmetric
+		pValue, effect = mannWhitney(tt.y, tt.x)
+		if math.Abs(pValue-tt.pValue) > 1e-9 || math.Abs(effect-(1-tt.effect)) > 1e-9 {
+			t.Errorf("%s swapped: p-value %v and effect %v, want %v and %v", tt.name, pValue, effect, tt.pValue, 1-tt.effect)
+		}
+	}
+}
+
+func TestMedianOf(t *testing.T) {
+	if got := medianOf([]time.Duration{3, 1, 2}); got != 2 {
+		t.Errorf("median of 3 values is %v", got)
+	}
+	if got := medianOf([]time.Duration{4, 1, 3, 2}); got != 2 {
+		t.Errorf("median of 4 values is %v", got)
+	}
+}
+
+// anomalyWindow returns n requests of frontend calling backend, the backend taking backend ms and up to 4 more
+func anomalyWindow(rng *rand.Rand, n, backend int) []*model.Trace {
+	traces := make([]*model.Trace, 0, n)
+	for i := 0; i < n; i++ {
+		child := backend + rng.Intn(5)
+		traces = append(traces, buildTestTrace(
+			testSpan{1, "frontend", 0, child + 5 + rng.Intn(3), nil},
+			testSpan{2, "backend", 2, child, []model.SpanRef{childOf(1)}},
+		))
+	}
+	return traces
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	d := NewAnomalyDetector(3, DefaultSignificance, 20, AnalysisOptions{})
+	for i := 0; i < 3; i++ {
+		if anomalies := d.Observe(anomalyWindow(rng, 40, 20)); len(anomalies) != 0 {
+			t.Errorf("anomalies in the baseline window %d: %v", i, anomalies)
+		}
+	}
+	if anomalies := d.Observe(anomalyWindow(rng, 40, 20)); len(anomalies) != 0 {
+		t.Errorf("anomalies of an unchanged window: %v", anomalies)
+	}
+
+	// the backend slows down, the frontend does not
+	anomalies := d.Observe(anomalyWindow(rng, 40, 30))
+	if len(anomalies) != 1 {
+		t.Fatalf("anomalies are %v, want the backend", anomalies)
+	}
+	backend := anomalies[0]
+	if backend.Service != "backend" || backend.PValue >= DefaultSignificance || backend.Effect != 1 ||
+		backend.Shift < 8*time.Millisecond || backend.Shift > 12*time.Millisecond {
+		t.Errorf("anomaly is %+v", backend)
+	}
+	if backend.Samples != 40 || backend.BaselineSamples != 120 {
+		t.Errorf("tested %d samples against %d", backend.Samples, backend.BaselineSamples)
+	}
+
+	// too few samples to test
+	if anomalies := d.Observe(anomalyWindow(rng, 10, 30)); len(anomalies) != 0 {
+		t.Errorf("anomalies of a small window: %v", anomalies)
+	}
+	// after numWindows, the slow backend is the baseline
+	d.Observe(anomalyWindow(rng, 40, 30))
+	if anomalies := d.Observe(anomalyWindow(rng, 40, 30)); len(anomalies) != 0 {
+		t.Errorf("anomalies once the change is the baseline: %v", anomalies)
+	}
+}
@@ -0,0 +1,149 @@
+package extractor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	DefaultTailQuantile = 0.99
+	// traces between these quantiles of the end-to-end latency are the median ones
+	medianLowerQuantile = 0.4
+	medianUpperQuantile = 0.6
+)
+
+// PodBlame is the extra time a pod spends on the critical path of the tail requests, compared to the median ones
+type PodBlame struct {
+	Pod string
+	// ExtraMs is TailMs - MedianMs
+	ExtraMs float64
+	// mean critical path contributions of the pod in the tail and in the median traces, in ms
+	TailMs   float64
+	MedianMs float64
+}
+
+type blameTrace struct {
+	latency       time.Duration
+	weight        float64
+	contributions map[string]time.Duration
+}
+
+// AttributeTailLatency ranks the pods by how much more they contribute to the critical paths of the traces above
+// tailQuantile of the end-to-end latency than to those around the median. Requests of different entry operations
+// are only compared with each other, and the operations are weighted by their tail traces. Traces that are not a
+// single tree are left out, their critical paths miss segments.
+func AttributeTailLatency(traces []*model.Trace, tailQuantile float64, options AnalysisOptions) []PodBlame {
+	byOperation := make(map[string][]*blameTrace)
+	for _, trace := range traces {
+		graph := NewGraph(trace, options)
+		if !graph.GetCompleteness().Complete() {
+			continue
+		}
+		root := graph.GetRoot()
+		byOperation[root.GetOperationName()] = append(byOperation[root.GetOperationName()], &blameTrace{
+			latency:       root.GetDuration(),
+			weight:        options.TraceWeight(trace),
+			contributions: graph.GetCriticalPath().GetContributionByPod(),
+		})
+	}
+
+	// sums of the per operation figures, weighted by the tail weight of the operation
+	tails := make(map[string]float64)
+	medians := make(map[string]float64)
+	var totalWeight float64
+	for _, blameTraces := range byOperation {
+		tail, median := splitTailAndMedian(blameTraces, tailQuantile)
+		if len(tail) == 0 || len(median) == 0 {
+			continue
+		}
+		tailWeight := 0.0
+		for _, bt := range tail {
+			tailWeight += bt.weight
+		}
+		totalWeight += tailWeight
+		for pod, contribution := range meanContributions(tail) {
+			tails[pod] += contribution * tailWeight
+		}
+		for pod, contribution := range meanContributions(median) {
+			medians[pod] += contribution * tailWeight
+		}
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	pods := make(map[string]struct{}, len(tails))
+	for pod := range tails {
+		pods[pod] = struct{}{}
+	}
+	for pod := range medians {
+		pods[pod] = struct{}{}
+	}
+	blames := make([]PodBlame, 0, len(pods))
+	for pod := range pods {
+		blame := PodBlame{
+			Pod:      pod,
+			TailMs:   tails[pod] / totalWeight,
+			MedianMs: medians[pod] / totalWeight,
+		}
+		blame.ExtraMs = blame.TailMs - blame.MedianMs
+		blames = append(blames, blame)
+	}
+	sort.Slice(blames, func(i, j int) bool {
+		if blames[i].ExtraMs != blames[j].ExtraMs {
+			return blames[i].ExtraMs > blames[j].ExtraMs
+		}
+		return blames[i].Pod < blames[j].Pod
+	})
+	return blames
+}
+
+// splitTailAndMedian sorts traces by latency and returns those above tailQuantile and those between
+// medianLowerQuantile and medianUpperQuantile, quantiles of the sampling weights
+func splitTailAndMedian(traces []*blameTrace, tailQuantile float64) ([]*blameTrace, []*blameTrace) {
+	sort.SliceStable(traces, func(i, j int) bool {
+		return traces[i].latency < traces[j].latency
+	})
+	var total float64
+	for _, bt := range traces {
+		total += bt.weight
+	}
+
+	var tail, median []*blameTrace
+	var below float64
+	for _, bt := range traces {
+		// the part of the weight up to the middle of the trace
+		q := (below + bt.weight/2) / total
+		below += bt.weight
+		switch {
+		case q > tailQuantile:
+			tail = append(tail, bt)
+		case q >= medianLowerQuantile && q <= medianUpperQuantile:
+			median = append(median, bt)
+		}
+	}
+	// a few traces have nothing above tailQuantile, the slowest one is the tail then
+	if len(tail) == 0 && len(traces) > 1 {
+		tail = traces[len(traces)-1:]
+	}
+	return tail, median
+}
+
+// meanContributions averages the contributions of each pod over traces in ms, weighted for sampling,
+// a pod missing from a trace contributes nothing to it
+func meanContributions(traces []*blameTrace) map[string]float64 {
+	var total float64
+	sums := make(map[string]float64)
+	for _, bt := range traces {
+		total += bt.weight
+		for pod, contribution := range bt.contributions {
+			sums[pod] += float64(contribution) / float64(time.Millisecond) * bt.weight
+		}
+	}
+	for pod := range sums {
+		sums[pod] /= total
+	}
+	return sums
+}
@@ -0,0 +1,111 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestAttributeTailLatencyToSlowPod(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	slowPod := FixturePodName("post-storage-service", 0)
+	// the pod is slow in a tenth of the requests, which make the tail
+	fixtures := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 900, Start: start, Duration: 9 * time.Second, PodsPerService: 2, Seed: 1})
+	fixtures = append(fixtures, GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 100, Start: start, Duration: 9 * time.Second,
+		PodsPerService: 2, SlowPod: slowPod, SlowFactor: 20, Seed: 2})...)
+
+	blames := AttributeTailLatency(fixtures, 0.9, AnalysisOptions{})
+	if len(blames) == 0 {
+		t.Fatal("no pod blamed")
+	}
+	blamed := blames[0]
+	if blamed.Pod != slowPod {
+		t.Fatalf("blamed %s, want %s: %v", blamed.Pod, slowPod, blames)
+	}
+	if blamed.ExtraMs <= 0 || blamed.TailMs <= blamed.MedianMs || blamed.ExtraMs != blamed.TailMs-blamed.MedianMs {
+		t.Errorf("blame of %s is %+v", slowPod, blamed)
+	}
+	for _, blame := range blames[1:] {
+		if blame.ExtraMs > blamed.ExtraMs/4 {
+			t.Errorf("%s is blamed for %.2fms, %s for %.2fms", blame.Pod, blame.ExtraMs, slowPod, blamed.ExtraMs)
+		}
+	}
+}
+
+func TestAttributeTailLatencyByOperation(t *testing.T) {
+	request := func(operation string, root, child int, service string) *model.Trace {
+		trace := buildTestTrace(
+			testSpan{1, "frontend", 0, root, nil},
+			testSpan{2, service, 1, child, []model.SpanRef{childOf(1)}},
+		)
+		trace.Spans[0].OperationName = operation
+		return trace
+	}
+	var traces []*model.Trace
+	for i := 0; i < 50; i++ {
+		traces = append(traces, request("/read", 5, 2, "cache"), request("/write", 50, 40, "db"))
+	}
+	// the slowest reads are still much faster than any write, which are compared with each other
+	for i := 0; i < 5; i++ {
+		traces = append(traces, request("/read", 11, 8, "cache"))
+	}
+	// and a trace which is not a single tree is left out
+	traces = append(traces, buildTestTrace(testSpan{2, "db", 1, 400, []model.SpanRef{childOf(1)}}))
+
+	blames := AttributeTailLatency(traces, DefaultTailQuantile, AnalysisOptions{})
+	byPod := make(map[string]PodBlame, len(blames))
+	for _, blame := range blames {
+		byPod[blame.Pod] = blame
+	}
+	if len(blames) != 3 || blames[0].Pod != "cache-0" {
+		t.Fatalf("blames are %v", blames)
+	}
+	// 6ms more in the tail of the reads, nothing in that of the writes, weighted alike
+	if cache := byPod["cache-0"]; cache.ExtraMs != 3 || cache.TailMs != 4 || cache.MedianMs != 1 {
+		t.Errorf("blame of the cache is %+v", cache)
+	}
+	if db := byPod["db-0"]; db.ExtraMs != 0 || db.TailMs != 20 {
+		t.Errorf("blame of the db is %+v", db)
+	}
+	if frontend := byPod["frontend-0"]; frontend.ExtraMs != 0 {
+		t.Errorf("blame of the frontend is %+v", frontend)
+	}
+
+	if blames := AttributeTailLatency(nil, DefaultTailQuantile, AnalysisOptions{}); blames != nil {
+		t.Errorf("blames without traces are %v", blames)
+	}
+}
+
+func TestSplitTailAndMedian(t *testing.T) {
+	traces := make([]*blameTrace, 0, 10)
+	for i := 10; i >= 1; i-- {
+		bt := &blameTrace{latency: time.Duration(i) * time.Millisecond, weight: 1}
+		if i == 1 {
+			// the fastest trace stands for 11 requests, so the median is the second fastest
+			bt.weight = 11
+		}
+		traces = append(traces, bt)
+	}
+	tail, median := splitTailAndMedian(traces, 0.9)
+	latencies := func(bts []*blameTrace) []uint64 {
+		ms := make([]uint64, 0, len(bts))
+		for _, bt := range bts {
+			ms = append(ms, uint64(bt.latency/time.Millisecond))
+		}
+		return ms
+	}
+	if !equalIDs(latencies(tail), []uint64{9, 10}) {
+		t.Errorf("tail is %v, want 9ms and 10ms", latencies(tail))
+	}
+	if !equalIDs(latencies(median), []uint64{2}) {
+		t.Errorf("median is %v, want 2ms", latencies(median))
+	}
+
+	// too few traces for the quantile, the slowest is the tail
+	few := []*blameTrace{{latency: 10 * time.Millisecond, weight: 1}, {latency: 3 * time.Millisecond, weight: 1}}
+	tail, _ = splitTailAndMedian(few, 0.99)
+	if !equalIDs(latencies(tail), []uint64{10}) {
+		t.Errorf("tail of 2 traces is %v, want 10ms", latencies(tail))
+	}
+}
@@ -0,0 +1,48 @@
+package extractor
+
+import (
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ExtractBottleNeckPod picks the pod whose contribution to the critical paths of traces varies the most (p99/p50)
+func ExtractBottleNeckPod(traces []*model.Trace, options AnalysisOptions) string {
+	bottlenecks := make(map[string]*Sketch)
+	for _, trace := range traces {
+		weight := options.TraceWeight(trace)
+		graph := NewGraph(trace, options)
+		for pod, contribution := range graph.GetCriticalPath().GetContributionByPod() {
+			sketch, ok := bottlenecks[pod]
+			if !ok {
+				sketch = NewSketch()
+				bottlenecks[pod] = sketch
+			}
+			sketch.AddWeighted(contribution, weight)
+		}
+	}
+	return mostVariable(bottlenecks)
+}
+
+// ExtractBottleNeckPodBySelfTime picks the pod whose self time varies the most (p99/p50),
+// upstream pods are not charged for the time they wait on downstream ones
+func ExtractBottleNeckPodBySelfTime(traces []*model.Trace, options AnalysisOptions) string {
+	return mostVariable(GetSelfTimeDistributionByPod(traces, options))
+}
+
+func mostVariable(bottlenecks map[string]*Sketch) string {
+	var bottleneck string
+	max := 0.0
+	for pod, sketch := range bottlenecks {
+		lat50 := sketch.Quantile(0.5)
+		lat99 := sketch.Quantile(0.99)
+		if lat50 == 0 {
+			continue
+		}
+		qos := float64(lat99) / float64(lat50)
+		if qos > max {
+			max = qos
+			bottleneck = pod
+		}
+	}
+
+	return bottleneck
+}
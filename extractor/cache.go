@@ -0,0 +1,108 @@
+package extractor
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// traceCache is an LRU cache of decoded traces
+type traceCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[model.TraceID]*list.Element
+}
+
+type traceCacheEntry struct {
+	traceID model.TraceID
+	trace   *model.Trace
+}
+
+func newTraceCache(capacity int) *traceCache {
+	return &traceCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[model.TraceID]*list.Element),
+	}
+}
+
+func (c *traceCache) get(traceID model.TraceID) (*model.Trace, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[traceID]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*traceCacheEntry).trace, true
+}
+
+func (c *traceCache) add(traceID model.TraceID, trace *model.Trace) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[traceID]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*traceCacheEntry).trace = trace
+		return
+	}
+	c.items[traceID] = c.ll.PushFront(&traceCacheEntry{traceID: traceID, trace: trace})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*traceCacheEntry).traceID)
+	}
+}
+
+func (c *traceCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[model.TraceID]*list.Element)
+}
+
+// indexCache keeps the values listed from the badger indexes for ttl, by the prefix scanned
+type indexCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]indexCacheEntry
+}
+
+type indexCacheEntry struct {
+	values  []string
+	expires time.Time
+}
+
+func newIndexCache(ttl time.Duration) *indexCache {
+	return &indexCache{
+		ttl:     ttl,
+		entries: make(map[string]indexCacheEntry),
+	}
+}
+
+// get returns the values of prefix, listed by load if they are missing or expired
+func (c *indexCache) get(prefix []byte, load func() ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := c.entries[string(prefix)]; ok && now.Before(e.expires) {
+		return e.values, nil
+	}
+	values, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[string(prefix)] = indexCacheEntry{values: values, expires: now.Add(c.ttl)}
+	return values, nil
+}
+
+func (c *indexCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]indexCacheEntry)
+}
@@ -0,0 +1,217 @@
+package extractor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// Signature is the canonical form of the call tree of the graph: every span is service:operation, followed by the
+// sorted set of the signatures of its children, so the shape does not depend on timing, ids or how many times
+// a call is repeated. FollowsFrom children are marked with ~
+func (g *Graph) Signature() string {
+	if g.root == nil {
+		return ""
+	}
+	return spanSignature(g.root)
+}
+
+func spanSignature(sp *Span) string {
+	label := sp.serviceName + ":" + sp.operationName
+	if sp.synthetic {
+		label = "<root>"
+	}
+	if sp.kind == FollowsFromEdge && sp.parent != nil {
+		label = "~" + label
+	}
+
+	children := make(map[string]struct{}, len(sp.children)+len(sp.followers))
+	for _, child := range sp.children {
+		children[spanSignature(child)] = struct{}{}
+	}
+	for _, follower := range sp.followers {
+		children[spanSignature(follower)] = struct{}{}
+	}
+	if len(children) == 0 {
+		return label
+	}
+	signatures := make([]string, 0, len(children))
+	for signature := range children {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	return label + "(" + strings.Join(signatures, ",") + ")"
+}
+
+// ClassID is a short hash of the signature
+func (g *Graph) ClassID() string {
+	return classID(g.Signature())
+}
+
+func classID(signature string) string {
+	h := fnv.New64a()
+	h.Write([]byte(signature))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func spanLabel(sp *Span) string {
+	return sp.serviceName + ":" + sp.operationName
+}
+
+// RequestClass gathers the traces of one call tree shape
+type RequestClass struct {
+	ID            string
+	Signature     string
+	RootOperation string
+	Count         int
+	// Weight is the count weighted for sampling
+	Weight  float64
+	Latency *Sketch
+	// FirstSeen is when the classifier saw the class for the first time
+	FirstSeen time.Time
+	// New is set if the class showed up after the first classification
+	New bool
+
+	traces []*model.Trace
+	// service:operation on the critical path, in the order of the first trace, and what they contributed
+	path         []string
+	contribution map[string]*Sketch
+}
+
+// ClassPathStep is a span of the critical path of a class, identified by service:operation
+type ClassPathStep struct {
+	Node         string
+	Contribution *Sketch
+}
+
+// GetCriticalPath returns the steps of the critical path of the class, with what they contributed over all of its traces
+func (c *RequestClass) GetCriticalPath() []ClassPathStep {
+	steps := make([]ClassPathStep, 0, len(c.path))
+	for _, node := range c.path {
+		steps = append(steps, ClassPathStep{Node: node, Contribution: c.contribution[node]})
+	}
+	return steps
+}
+
+// GetTraces returns the traces of the class, e.g. to find its bottleneck with ExtractBottleNeckPod
+func (c *RequestClass) GetTraces() []*model.Trace {
+	return c.traces
+}
+
+func (c *RequestClass) add(trace *model.Trace, graph *Graph, weight float64) {
+	c.Count++
+	c.Weight += weight
+	c.traces = append(c.traces, trace)
+	if root := graph.GetRoot(); root != nil {
+		c.Latency.AddWeighted(root.duration, weight)
+	}
+
+	seen := make(map[string]time.Duration)
+	for curr := graph.GetCriticalPath().GetHead(); curr != nil; curr = curr.next {
+		node := spanLabel(curr.span)
+		if _, ok := c.contribution[node]; !ok {
+			c.contribution[node] = NewSketch()
+			c.path = append(c.path, node)
+		}
+		seen[node] += curr.GetContribution()
+	}
+	for node, contribution := range seen {
+		c.contribution[node].AddWeighted(contribution, weight)
+	}
+}
+
+// classRetention is the number of classifications a class is remembered for after it was last seen
+const classRetention = 120
+
+// RequestClassifier groups traces into request classes by Signature, and remembers the classes it has seen
+// so the ones showing up later, e.g. after a deploy changed the call pattern, are flagged as new. A class
+// not seen for classRetention classifications is forgotten, and new again if it comes back.
+type RequestClassifier struct {
+	mu sync.Mutex
+	// number of classifications so far
+	window  int
+	seen    map[string]*classSeen
+	options AnalysisOptions
+}
+
+type classSeen struct {
+	firstSeen time.Time
+	// the classification the class was last seen in
+	lastWindow int
+}
+
+func NewRequestClassifier(options AnalysisOptions) *RequestClassifier {
+	return &RequestClassifier{
+		seen:    make(map[string]*classSeen),
+		options: options,
+	}
+}
+
+// Classify returns the classes of traces, most frequent first. Traces that are not a single tree yet, e.g. still
+// missing spans, are left out: their partial shapes would show up as new classes.
+func (rc *RequestClassifier) Classify(traces []*model.Trace) []*RequestClass {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	baseline := len(rc.seen) == 0
+	classes := make(map[string]*RequestClass)
+	for _, trace := range traces {
+		graph := NewGraph(trace, rc.options)
+		root := graph.GetRoot()
+		if root == nil || !graph.GetCompleteness().Complete() {
+			continue
+		}
+		signature := graph.Signature()
+		id := classID(signature)
+		class, ok := classes[id]
+		if !ok {
+			seen, known := rc.seen[id]
+			if !known {
+				seen = &classSeen{firstSeen: now}
+				rc.seen[id] = seen
+			}
+			seen.lastWindow = rc.window
+			class = &RequestClass{
+				ID:            id,
+				Signature:     signature,
+				RootOperation: root.operationName,
+				Latency:       NewSketch(),
+				FirstSeen:     seen.firstSeen,
+				New:           !known && !baseline,
+				contribution:  make(map[string]*Sketch),
+			}
+			classes[id] = class
+		}
+		class.add(trace, graph, rc.options.TraceWeight(trace))
+	}
+
+	for id, seen := range rc.seen {
+		if rc.window-seen.lastWindow >= classRetention {
+			delete(rc.seen, id)
+		}
+	}
+	rc.window++
+
+	results := make([]*RequestClass, 0, len(classes))
+	for _, class := range classes {
+		results = append(results, class)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Count != results[j].Count {
+			return results[i].Count > results[j].Count
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
+// ClassifyTraces groups traces into request classes without remembering them
+func ClassifyTraces(traces []*model.Trace, options AnalysisOptions) []*RequestClass {
+	return NewRequestClassifier(options).Classify(traces)
+}
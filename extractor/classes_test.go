@@ -0,0 +1,168 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestSignature(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		want  string
+	}{
+		{
+			name:  "single span",
+			trace: buildTestTrace(testSpan{1, "frontend", 0, 10, nil}),
+			want:  "frontend:op",
+		},
+		{
+			// repeated calls and their order do not change the shape
+			name: "repeated calls",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "user", 1, 2, []model.SpanRef{childOf(1)}},
+				testSpan{3, "backend", 3, 2, []model.SpanRef{childOf(1)}},
+				testSpan{4, "backend", 5, 2, []model.SpanRef{childOf(1)}},
+			),
+			want: "frontend:op(backend:op,user:op)",
+		},
+		{
+			name: "follower",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 2, []model.SpanRef{childOf(1)}},
+				testSpan{3, "queue", 5, 20, []model.SpanRef{followsFrom(1)}},
+			),
+			want: "frontend:op(backend:op,~queue:op)",
+		},
+		{
+			name: "several roots",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 12, 2, nil},
+			),
+			want: "<root>(backend:op,frontend:op)",
+		},
+		{
+			name:  "empty",
+			trace: &model.Trace{},
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		if got := NewGraph(tt.trace, AnalysisOptions{}).Signature(); got != tt.want {
+			t.Errorf("%s: signature is %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyFixtures(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	fixtures := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 500, Start: start, Duration: 5 * time.Second, PodsPerService: 3, Seed: 1})
+	counts := make(map[string]int)
+	for _, trace := range fixtures {
+		counts[trace.Spans[0].OperationName]++
+	}
+
+	// one class by entry operation, whatever the pods, the timing and the ids
+	classes := ClassifyTraces(fixtures, AnalysisOptions{})
+	if len(classes) != len(socialNetworkWorkload) {
+		t.Fatalf("%d classes, want %d", len(classes), len(socialNetworkWorkload))
+	}
+	total := 0
+	for i, class := range classes {
+		if class.Signature != fixtureSignature(class.RootOperation) {
+			t.Errorf("class of %s has signature %s, want %s", class.RootOperation, class.Signature, fixtureSignature(class.RootOperation))
+		}
+		if class.ID != classID(class.Signature) || class.New {
+			t.Errorf("class of %s has ID %s, new %v", class.RootOperation, class.ID, class.New)
+		}
+		if class.Count != counts[class.RootOperation] || len(class.GetTraces()) != class.Count || class.Weight != float64(class.Count) {
+			t.Errorf("class of %s has %d traces, want %d", class.RootOperation, class.Count, counts[class.RootOperation])
+		}
+		if class.Latency.Count() != class.Count {
+			t.Errorf("class of %s has %d latencies", class.RootOperation, class.Latency.Count())
+		}
+		if i > 0 && class.Count > classes[i-1].Count {
+			t.Errorf("class of %s is more frequent than the one before", class.RootOperation)
+		}
+		total += class.Count
+	}
+	if total != len(fixtures) {
+		t.Errorf("classes have %d traces, want %d", total, len(fixtures))
+	}
+	if classes[0].RootOperation != "/wrk2-api/home-timeline/read" {
+		t.Errorf("most frequent class is %s", classes[0].RootOperation)
+	}
+}
+
+func TestClassCriticalPath(t *testing.T) {
+	request := func(backend int) *model.Trace {
+		return buildTestTrace(
+			testSpan{1, "frontend", 0, 10, nil},
+			testSpan{2, "backend", 1, backend, []model.SpanRef{childOf(1)}},
+		)
+	}
+	classes := ClassifyTraces([]*model.Trace{request(4), request(6)}, AnalysisOptions{})
+	if len(classes) != 1 {
+		t.Fatalf("%d classes, want 1", len(classes))
+	}
+	steps := classes[0].GetCriticalPath()
+	if len(steps) != 2 || steps[0].Node != "frontend:op" || steps[1].Node != "backend:op" {
+		t.Fatalf("critical path is %v", steps)
+	}
+	// the segments of a span add up within a trace
+	if got := steps[0].Contribution.Quantiles([]float64{0, 1}); got[0] != 4*time.Millisecond || got[1] != 6*time.Millisecond {
+		t.Errorf("contributions of the frontend range over %v", got)
+	}
+	if got := steps[1].Contribution.Mean(); got != 5*time.Millisecond {
+		t.Errorf("mean contribution of the backend is %v, want 5ms", got)
+	}
+}
+
+func TestRequestClassifier(t *testing.T) {
+	read := buildTestTrace(
+		testSpan{1, "frontend", 0, 10, nil},
+		testSpan{2, "backend", 1, 2, []model.SpanRef{childOf(1)}},
+	)
+	cached := buildTestTrace(testSpan{1, "frontend", 0, 10, nil})
+	write := buildTestTrace(
+		testSpan{1, "frontend", 0, 10, nil},
+		testSpan{2, "backend", 1, 2, []model.SpanRef{childOf(1)}},
+		testSpan{3, "db", 2, 1, []model.SpanRef{childOf(2)}},
+	)
+	// still missing its root, its shape is partial
+	partial := buildTestTrace(testSpan{2, "backend", 1, 2, []model.SpanRef{childOf(1)}})
+
+	rc := NewRequestClassifier(AnalysisOptions{})
+	newClasses := func(traces ...*model.Trace) map[string]bool {
+		news := make(map[string]bool)
+		for _, class := range rc.Classify(traces) {
+			news[class.Signature] = class.New
+		}
+		return news
+	}
+
+	// the first classification is the baseline
+	if got := newClasses(read, cached, partial); len(got) != 2 || got["frontend:op(backend:op)"] || got["frontend:op"] {
+		t.Errorf("baseline classes are %v", got)
+	}
+	got := newClasses(read, write)
+	if len(got) != 2 || got["frontend:op(backend:op)"] || !got["frontend:op(backend:op(db:op))"] {
+		t.Errorf("classes after the baseline are %v", got)
+	}
+	if got := newClasses(write); got["frontend:op(backend:op(db:op))"] {
+		t.Error("class is new the second time")
+	}
+
+	// a class not seen for classRetention classifications is new again
+	for i := 0; i < classRetention; i++ {
+		newClasses(read)
+	}
+	if got := newClasses(read, cached); got["frontend:op(backend:op)"] || !got["frontend:op"] {
+		t.Errorf("classes after the retention are %v", got)
+	}
+}
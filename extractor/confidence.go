@@ -0,0 +1,112 @@
+package extractor
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultConfidence = 0.95
+)
+
+// QuantileEstimate is a quantile of a Sketch with its order-statistic confidence interval
+type QuantileEstimate struct {
+	Quantile float64
+	Value    time.Duration
+	Lower    time.Duration
+	Upper    time.Duration
+	Samples  int
+	// EffectiveSamples is what the samples are worth once weighted for sampling, the interval is built on it
+	EffectiveSamples float64
+	// Bounded is false when there are too few samples for an interval at the confidence level,
+	// Lower and Upper are the min and the max then
+	Bounded bool
+}
+
+// Meaningful tells whether the estimate can be acted on
+func (e QuantileEstimate) Meaningful(minSamples int) bool {
+	return e.Bounded && e.EffectiveSamples >= float64(minSamples)
+}
+
+// Estimate returns the q-quantile with a distribution-free confidence interval: between the l-th and the u-th
+// smallest samples, where the number of samples below the true quantile, Binomial(n, q), falls in [l, u)
+// with the confidence probability
+func (s *Sketch) Estimate(q, confidence float64) QuantileEstimate {
+	e := QuantileEstimate{
+		Quantile: q,
+		Value:    s.Quantile(q),
+		Lower:    s.min,
+		Upper:    s.max,
+		Samples:  s.count,
+
+		EffectiveSamples: s.EffectiveSampleSize(),
+	}
+	n := int(math.Round(e.EffectiveSamples))
+	if n == 0 {
+		return e
+	}
+
+	lower, upper := orderStatisticRanks(n, q, (1-confidence)/2)
+	if lower < 1 || upper > n {
+		return e
+	}
+	e.Bounded = true
+	e.Lower = rankValue(s, lower, n)
+	e.Upper = rankValue(s, upper, n)
+	return e
+}
+
+// MinSamples returns the fewest samples Estimate bounds the q-quantile with at confidence, q in (0, 1): the
+// interval needs a sample on either side of the quantile, e.g. 368 for the 0.99-quantile at 0.95. With fewer
+// the estimate is not Bounded, whatever the minimum it is held to
+func MinSamples(q, confidence float64) int {
+	alpha := (1 - confidence) / 2
+	// l >= 1 needs P(B = 0) = (1-q)^n <= alpha and u <= n needs P(B = n) = q^n <= alpha
+	return int(math.Ceil(math.Log(alpha) / math.Log(math.Max(q, 1-q))))
+}
+
+// rankValue returns the k-th smallest of n values, k in [1, n]
+func rankValue(s *Sketch, k, n int) time.Duration {
+	if n == 1 {
+		return s.Quantile(0.5)
+	}
+	return s.Quantile(float64(k-1) / float64(n-1))
+}
+
+// orderStatisticRanks returns the largest l with P(B < l) <= alpha and the smallest u with P(B >= u) <= alpha,
+// B ~ Binomial(n, q); l is 0 or u is n+1 if there is none
+func orderStatisticRanks(n int, q, alpha float64) (int, int) {
+	// pmf in log space, n is up to a few thousand traces
+	logPmf := func(k int) float64 {
+		lgN, _ := math.Lgamma(float64(n + 1))
+		lgK, _ := math.Lgamma(float64(k + 1))
+		lgNK, _ := math.Lgamma(float64(n - k + 1))
+		return lgN - lgK - lgNK + float64(k)*math.Log(q) + float64(n-k)*math.Log(1-q)
+	}
+	if q <= 0 || q >= 1 {
+		return 0, n + 1
+	}
+
+	lower := 0
+	cdf := 0.0
+	for k := 0; k < n; k++ {
+		// cdf is P(B < k+1)
+		cdf += math.Exp(logPmf(k))
+		if cdf > alpha {
+			break
+		}
+		lower = k + 1
+	}
+
+	upper := n + 1
+	tail := 0.0
+	for k := n; k >= 1; k-- {
+		// tail is P(B >= k)
+		tail += math.Exp(logPmf(k))
+		if tail > alpha {
+			break
+		}
+		upper = k
+	}
+	return lower, upper
+}
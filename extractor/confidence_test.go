@@ -0,0 +1,164 @@
+package extractor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// binomialCDF is P(B <= k) for B ~ Binomial(n, q), summed term by term
+func binomialCDF(n, k int, q float64) float64 {
+	cdf, pmf := 0.0, math.Pow(1-q, float64(n))
+	for i := 0; i <= k; i++ {
+		cdf += pmf
+		pmf *= float64(n-i) / float64(i+1) * q / (1 - q)
+	}
+	return cdf
+}
+
+func TestOrderStatisticRanks(t *testing.T) {
+	tests := []struct {
+		n            int
+		q            float64
+		lower, upper int
+	}{
+		// the tabulated 95% confidence intervals of the median, between the 6th and 15th of 20 values
+		{20, 0.5, 6, 15},
+		{100, 0.5, 40, 61},
+		// computed in exact arithmetic: the 0.99-quantile is bounded from 368 values, by the largest of them
+		{367, 0.99, 359, 368},
+		{368, 0.99, 360, 368},
+		{1000, 0.99, 983, 997},
+		{500, 0.9, 436, 464},
+		{10, 0.5, 2, 9},
+		{5, 0.5, 0, 6},
+	}
+	for _, tt := range tests {
+		lower, upper := orderStatisticRanks(tt.n, tt.q, 0.025)
+		if lower != tt.lower || upper != tt.upper {
+			t.Errorf("ranks of the %v-quantile of %d values are [%d, %d], want [%d, %d]", tt.q, tt.n, lower, upper, tt.lower, tt.upper)
+		}
+	}
+
+	// by definition, against the binomial distribution of sizes whose terms do not underflow
+	for _, n := range []int{1, 7, 30, 100} {
+		for _, q := range []float64{0.05, 0.5, 0.9, 0.99} {
+			alpha := 0.025
+			lower, upper := orderStatisticRanks(n, q, alpha)
+			if lower > 0 && binomialCDF(n, lower-1, q) > alpha+1e-12 || lower < n && binomialCDF(n, lower, q) <= alpha-1e-12 {
+				t.Errorf("lower rank of the %v-quantile of %d values is %d", q, n, lower)
+			}
+			if upper <= n && 1-binomialCDF(n, upper-1, q) > alpha+1e-12 || upper > 1 && 1-binomialCDF(n, upper-2, q) <= alpha-1e-12 {
+				t.Errorf("upper rank of the %v-quantile of %d values is %d", q, n, upper)
+			}
+		}
+	}
+}
+
+func TestMinSamples(t *testing.T) {
+	tests := []struct {
+		q, confidence float64
+		want          int
+	}{
+		{0.5, 0.95, 6},
+		{0.99, 0.95, 368},
+		{0.01, 0.95, 368},
+		{0.99, 0.9, 299},
+		{0.999, 0.95, 3688},
+	}
+	for _, tt := range tests {
+		got := MinSamples(tt.q, tt.confidence)
+		if got != tt.want {
+			t.Errorf("MinSamples(%v, %v) = %d, want %d", tt.q, tt.confidence, got, tt.want)
+		}
+		// the fewest samples Estimate bounds the quantile with
+		for n, bounded := range map[int]bool{got - 1: false, got: true} {
+			s := NewSketch()
+			for i := 1; i <= n; i++ {
+				s.Add(time.Duration(i) * time.Millisecond)
+			}
+			if e := s.Estimate(tt.q, tt.confidence); e.Bounded != bounded {
+				t.Errorf("%v-quantile of %d samples at %v is bounded: %v, want %v", tt.q, n, tt.confidence, e.Bounded, bounded)
+			}
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	s := NewSketch()
+	for i := 1; i <= 100; i++ {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+	// between the 40th and the 61st values
+	e := s.Estimate(0.5, 0.95)
+	if !e.Bounded || e.Samples != 100 || math.Abs(e.EffectiveSamples-100) > 1e-9 {
+		t.Fatalf("estimate is %+v", e)
+	}
+	checkRelativeError(t, "lower bound", e.Lower, 40*time.Millisecond, defaultSketchAccuracy)
+	checkRelativeError(t, "upper bound", e.Upper, 61*time.Millisecond, defaultSketchAccuracy)
+	if e.Lower > e.Value || e.Value > e.Upper {
+		t.Errorf("median %v is outside [%v, %v]", e.Value, e.Lower, e.Upper)
+	}
+	if !e.Meaningful(30) || e.Meaningful(101) {
+		t.Errorf("estimate of 100 samples is meaningful from 30: %v, from 101: %v", e.Meaningful(30), e.Meaningful(101))
+	}
+
+	// not bounded with too few samples for the confidence, whatever the minimum
+	e = s.Estimate(0.99, 0.95)
+	if e.Bounded || e.Meaningful(1) || e.Lower != s.Min() || e.Upper != s.Max() {
+		t.Errorf("estimate of the 0.99-quantile of 100 samples is %+v", e)
+	}
+
+	// weighted samples are worth fewer unweighted ones
+	weighted := NewSketch()
+	for i := 1; i <= 100; i++ {
+		weight := 1.0
+		if i%10 == 0 {
+			weight = 20
+		}
+		weighted.AddWeighted(time.Duration(i)*time.Millisecond, weight)
+	}
+	if e := weighted.Estimate(0.5, 0.95); e.EffectiveSamples >= 100 || e.Meaningful(100) {
+		t.Errorf("estimate of weighted samples is %+v", e)
+	}
+}
+
+func TestErrorRateInterval(t *testing.T) {
+	// Wilson score intervals at 95%
+	tests := []struct {
+		errors, spans int
+		lower, upper  float64
+	}{
+		{0, 10, 0, 0.2775},
+		{1, 10, 0.0179, 0.4042},
+		{5, 10, 0.2366, 0.7634},
+		{10, 10, 0.7225, 1},
+		{20, 100, 0.1334, 0.2888},
+	}
+	for _, tt := range tests {
+		for _, weight := range []float64{1, 4} {
+			r := &ErrorRate{}
+			for i := 0; i < tt.spans; i++ {
+				r.add(i < tt.errors, weight)
+			}
+			// a constant weight changes nothing
+			lower, upper := r.Interval(0.95)
+			if math.Abs(lower-tt.lower) > 1e-4 || math.Abs(upper-tt.upper) > 1e-4 {
+				t.Errorf("interval of %d errors in %d spans of weight %v is [%.4f, %.4f], want [%.4f, %.4f]",
+					tt.errors, tt.spans, weight, lower, upper, tt.lower, tt.upper)
+			}
+		}
+	}
+
+	if lower, upper := (&ErrorRate{}).Interval(0.95); lower != 0 || upper != 1 {
+		t.Errorf("interval without spans is [%v, %v]", lower, upper)
+	}
+
+	r := &ErrorRate{}
+	for i := 0; i < 100; i++ {
+		r.add(i < 20, 1)
+	}
+	if !r.Failing(0.1, 0.95, 30) || r.Failing(0.15, 0.95, 30) || r.Failing(0.1, 0.95, 101) {
+		t.Error("20 errors in 100 spans are failing at the wrong rates or sample sizes")
+	}
+}
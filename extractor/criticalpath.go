@@ -0,0 +1,98 @@
+package extractor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// buildCriticalPath walks backward from the end of the root: at each span the last finishing ChildOf child
+// before the cursor blocked the span, the rest of the time until the cursor is spent in the span itself.
+// FollowsFrom children never block their parent, and the gaps between the roots of a forest belong to no span.
+func (g *Graph) buildCriticalPath() *Path {
+	path := &Path{}
+	if g.root == nil {
+		return path
+	}
+
+	// collected from the end of the trace backward
+	segments := make([]*PathNode, 0)
+	var walk func(sp *Span, end time.Duration)
+	walk = func(sp *Span, end time.Duration) {
+		addSegment := func(start, end time.Duration) {
+			if end > start && !sp.synthetic {
+				segments = append(segments, &PathNode{span: sp, start: start, end: end})
+			}
+		}
+
+		children := make([]*Span, len(sp.children))
+		copy(children, sp.children)
+		sort.SliceStable(children, func(i, j int) bool {
+			return spanEnd(children[i]) > spanEnd(children[j])
+		})
+
+		cursor := end
+		for _, child := range children {
+			if cursor <= sp.startTime {
+				break
+			}
+			// started after the cursor, ran while the span was blocked on a later child
+			if child.startTime >= cursor {
+				continue
+			}
+			childEnd := spanEnd(child)
+			if childEnd > cursor {
+				childEnd = cursor
+			}
+			addSegment(childEnd, cursor)
+			walk(child, childEnd)
+			cursor = child.startTime
+			if cursor < sp.startTime {
+				cursor = sp.startTime
+			}
+		}
+		addSegment(sp.startTime, cursor)
+	}
+	walk(g.root, spanEnd(g.root))
+
+	var prev *PathNode
+	for i := len(segments) - 1; i >= 0; i-- {
+		if prev == nil {
+			path.head = segments[i]
+		} else {
+			prev.next = segments[i]
+		}
+		prev = segments[i]
+	}
+	return path
+}
+
+func spanEnd(sp *Span) time.Duration {
+	return sp.startTime + sp.duration
+}
+
+// GetContributionByPod sums the segments of the path by pod, leaving out the spans of unknown pods
+func (p *Path) GetContributionByPod() map[string]time.Duration {
+	contributions := make(map[string]time.Duration)
+	for curr := p.head; curr != nil; curr = curr.next {
+		if curr.span.podResolved {
+			contributions[curr.span.podName] += curr.GetContribution()
+		}
+	}
+	return contributions
+}
+
+// contributionBySpan sums the segments of the path by span
+func (p *Path) contributionBySpan() map[*Span]time.Duration {
+	contributions := make(map[*Span]time.Duration)
+	for curr := p.head; curr != nil; curr = curr.next {
+		contributions[curr.span] += curr.GetContribution()
+	}
+	return contributions
+}
+
+func (g *Graph) PrintCriticalPath() {
+	for curr := g.criticalPath.head; curr != nil; curr = curr.next {
+		fmt.Println(curr.span.podName, curr.start, curr.GetContribution())
+	}
+}
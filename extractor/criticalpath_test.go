@@ -0,0 +1,128 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// testSegment is a segment of the critical path, in ms from the start of the trace
+type testSegment struct {
+	id         uint64
+	start, end int
+}
+
+func TestCriticalPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		want  []testSegment
+	}{
+		{
+			name: "sequential children",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 5, 4, []model.SpanRef{childOf(1)}},
+			),
+			want: []testSegment{{1, 0, 1}, {2, 1, 4}, {1, 4, 5}, {3, 5, 9}, {1, 9, 10}},
+		},
+		{
+			// the parent waits on the child finishing last, the other one runs meanwhile
+			name: "parallel children",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 7, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 2, 4, []model.SpanRef{childOf(1)}},
+			),
+			want: []testSegment{{1, 0, 1}, {2, 1, 8}, {1, 8, 10}},
+		},
+		{
+			name: "overlapping children",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 4, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 3, 5, []model.SpanRef{childOf(1)}},
+			),
+			want: []testSegment{{1, 0, 1}, {2, 1, 3}, {3, 3, 8}, {1, 8, 10}},
+		},
+		{
+			name: "nested",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 8, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 2, 4, []model.SpanRef{childOf(2)}},
+			),
+			want: []testSegment{{1, 0, 1}, {2, 1, 2}, {3, 2, 6}, {2, 6, 9}, {1, 9, 10}},
+		},
+		{
+			// only the part of the child until the end of the parent is on the path
+			name: "child outliving its parent",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 5, 10, []model.SpanRef{childOf(1)}},
+			),
+			want: []testSegment{{1, 0, 5}, {2, 5, 10}},
+		},
+		{
+			name: "follower",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "queue", 2, 20, []model.SpanRef{followsFrom(1)}},
+			),
+			want: []testSegment{{1, 0, 10}},
+		},
+		{
+			// the gap between the roots belongs to no span
+			name: "several roots",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 2, nil},
+				testSpan{2, "backend", 5, 3, nil},
+			),
+			want: []testSegment{{1, 0, 2}, {2, 5, 8}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph(tt.trace, AnalysisOptions{})
+			var got []testSegment
+			for curr := g.GetCriticalPath().GetHead(); curr != nil; curr = curr.GetNext() {
+				got = append(got, testSegment{
+					id:    uint64(curr.GetSpan().spanID),
+					start: int(curr.GetStartTime() / time.Millisecond),
+					end:   int((curr.GetStartTime() + curr.GetContribution()) / time.Millisecond),
+				})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("path is %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("path is %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCriticalPathContributionByPod(t *testing.T) {
+	g := NewGraph(buildTestTrace(
+		testSpan{1, "frontend", 0, 10, nil},
+		testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}},
+		testSpan{3, "backend", 5, 4, []model.SpanRef{childOf(1)}},
+		testSpan{4, "", 9, 1, []model.SpanRef{childOf(1)}},
+	), AnalysisOptions{})
+
+	// the unresolved span is left out
+	want := map[string]time.Duration{"frontend-0": 2 * time.Millisecond, "backend-0": 7 * time.Millisecond}
+	got := g.GetCriticalPath().GetContributionByPod()
+	if len(got) != len(want) {
+		t.Fatalf("contributions are %v, want %v", got, want)
+	}
+	for pod, contribution := range want {
+		if got[pod] != contribution {
+			t.Errorf("contribution of %s is %v, want %v", pod, got[pod], contribution)
+		}
+	}
+}
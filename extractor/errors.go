@@ -0,0 +1,200 @@
+package extractor
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	errorTag          = "error"
+	httpStatusCodeTag = "http.status_code"
+	// log fields of the OpenTracing conventions
+	logEventField     = "event"
+	logErrorKindField = "error.kind"
+)
+
+// IsSpanFailed tells whether span reports a failure: an error tag other than false, a 5xx http.status_code,
+// or a log of an error event. Client errors (4xx) are not failures of the service.
+func IsSpanFailed(span *model.Span) bool {
+	for _, kv := range span.Tags {
+		switch kv.Key {
+		case errorTag:
+			if kv.VType == model.BoolType {
+				if kv.Bool() {
+					return true
+				}
+			} else if v := kv.AsString(); v != "" && v != "false" {
+				return true
+			}
+		case httpStatusCodeTag:
+			if code, err := strconv.Atoi(kv.AsString()); err == nil && code >= 500 {
+				return true
+			}
+		}
+	}
+	for _, log := range span.Logs {
+		for _, kv := range log.Fields {
+			if kv.Key == logEventField && kv.AsString() == errorTag || kv.Key == logErrorKindField {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrorRate counts the spans of a service, operation or pod and the failed ones, also weighted for sampling
+type ErrorRate struct {
+	Spans       int
+	Errors      int
+	Weight      float64
+	ErrorWeight float64
+	// sum of the squared weights, for the effective sample size
+	sumSquares float64
+}
+
+func (r *ErrorRate) add(failed bool, weight float64) {
+	r.Spans++
+	r.Weight += weight
+	r.sumSquares += weight * weight
+	if failed {
+		r.Errors++
+		r.ErrorWeight += weight
+	}
+}
+
+// Rate returns the weighted part of the spans that failed, 0 without spans
+func (r *ErrorRate) Rate() float64 {
+	if r.Weight == 0 {
+		return 0
+	}
+	return r.ErrorWeight / r.Weight
+}
+
+// EffectiveSampleSize is what the spans are worth once weighted, see Sketch.EffectiveSampleSize
+func (r *ErrorRate) EffectiveSampleSize() float64 {
+	if r.sumSquares == 0 {
+		return 0
+	}
+	return r.Weight * r.Weight / r.sumSquares
+}
+
+// Interval returns the Wilson score interval of the rate at confidence, built on the effective sample size
+func (r *ErrorRate) Interval(confidence float64) (float64, float64) {
+	n := r.EffectiveSampleSize()
+	if n == 0 {
+		return 0, 1
+	}
+	p := r.Rate()
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	center := (p + z*z/(2*n)) / (1 + z*z/n)
+	halfWidth := z / (1 + z*z/n) * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	return math.Max(0, center-halfWidth), math.Min(1, center+halfWidth)
+}
+
+// Failing tells whether the rate is above maxRate with the confidence, on at least minSamples spans
+func (r *ErrorRate) Failing(maxRate, confidence float64, minSamples int) bool {
+	lower, _ := r.Interval(confidence)
+	return r.EffectiveSampleSize() >= float64(minSamples) && lower > maxRate
+}
+
+type ErrorRates struct {
+	ByService   map[string]*ErrorRate
+	ByOperation map[OperationKey]*ErrorRate
+	// spans of unresolved pods are left out
+	ByPod map[string]*ErrorRate
+}
+
+// GetErrorRates counts the failed spans of traces, e.g. those of the last interval, by service, operation and pod,
+// weighted for sampling
+func GetErrorRates(traces []*model.Trace, options AnalysisOptions) *ErrorRates {
+	rates := &ErrorRates{
+		ByService:   make(map[string]*ErrorRate),
+		ByOperation: make(map[OperationKey]*ErrorRate),
+		ByPod:       make(map[string]*ErrorRate),
+	}
+	identifier := options.podIdentifier()
+	for _, trace := range traces {
+		weight := options.TraceWeight(trace)
+		for _, span := range trace.Spans {
+			if span.Process == nil {
+				continue
+			}
+			failed := IsSpanFailed(span)
+			service := span.Process.ServiceName
+			errorRateOf(rates.ByService, service).add(failed, weight)
+
+			key := OperationKey{Service: service, Operation: span.OperationName}
+			operationErrorRateOf(rates.ByOperation, key).add(failed, weight)
+
+			if podName, resolved := identifier.Identify(span); resolved {
+				errorRateOf(rates.ByPod, podName).add(failed, weight)
+			}
+		}
+	}
+	return rates
+}
+
+// RequestErrorRate counts the requests of an entry operation. Embedded are the requests whose root span failed,
+// answered with an error; AnySpan counts a request failed if any of its spans did, so a downstream failure the
+// root recovered from, e.g. by a retry or a fallback, fails it too. The Spans of both are the requests.
+type RequestErrorRate struct {
+	ErrorRate
+	AnySpan ErrorRate
+}
+
+// GetRequestErrorRates counts the requests of traces and the failed ones by the service and operation of their root
+// span, weighted for sampling
+func GetRequestErrorRates(traces []*model.Trace, options AnalysisOptions) map[OperationKey]*RequestErrorRate {
+	rates := make(map[OperationKey]*RequestErrorRate)
+	for _, trace := range traces {
+		root := rootSpan(trace)
+		if root == nil || root.Process == nil {
+			continue
+		}
+		anyFailed := false
+		for _, span := range trace.Spans {
+			if IsSpanFailed(span) {
+				anyFailed = true
+				break
+			}
+		}
+		key := OperationKey{Service: root.Process.ServiceName, Operation: root.OperationName}
+		rate, ok := rates[key]
+		if !ok {
+			rate = &RequestErrorRate{}
+			rates[key] = rate
+		}
+		weight := options.TraceWeight(trace)
+		rate.add(IsSpanFailed(root), weight)
+		rate.AnySpan.add(anyFailed, weight)
+	}
+	return rates
+}
+
+func errorRateOf(m map[string]*ErrorRate, name string) *ErrorRate {
+	rate, ok := m[name]
+	if !ok {
+		rate = &ErrorRate{}
+		m[name] = rate
+	}
+	return rate
+}
+
+func operationErrorRateOf(m map[OperationKey]*ErrorRate, key OperationKey) *ErrorRate {
+	rate, ok := m[key]
+	if !ok {
+		rate = &ErrorRate{}
+		m[key] = rate
+	}
+	return rate
+}
+
+// GetOperation returns the error rate of an operation of service, empty if it has no spans
+func (rs *ErrorRates) GetOperation(service, operation string) *ErrorRate {
+	if rate, ok := rs.ByOperation[OperationKey{Service: service, Operation: operation}]; ok {
+		return rate
+	}
+	return &ErrorRate{}
+}
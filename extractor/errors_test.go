@@ -0,0 +1,123 @@
+package extractor
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestIsSpanFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		tags   []model.KeyValue
+		logs   []model.Log
+		failed bool
+	}{
+		{"no tags", nil, nil, false},
+		{"error true", []model.KeyValue{model.Bool("error", true)}, nil, true},
+		{"error false", []model.KeyValue{model.Bool("error", false)}, nil, false},
+		{"error string", []model.KeyValue{model.String("error", "true")}, nil, true},
+		{"error string false", []model.KeyValue{model.String("error", "false")}, nil, false},
+		{"server error", []model.KeyValue{model.Int64("http.status_code", 503)}, nil, true},
+		{"server error string", []model.KeyValue{model.String("http.status_code", "500")}, nil, true},
+		{"client error", []model.KeyValue{model.Int64("http.status_code", 404)}, nil, false},
+		{"error event", nil, []model.Log{{Fields: []model.KeyValue{model.String("event", "error")}}}, true},
+		{"error kind", nil, []model.Log{{Fields: []model.KeyValue{model.String("error.kind", "Timeout")}}}, true},
+		{"other event", nil, []model.Log{{Fields: []model.KeyValue{model.String("event", "retry")}}}, false},
+	}
+	for _, tt := range tests {
+		if got := IsSpanFailed(&model.Span{Tags: tt.tags, Logs: tt.logs}); got != tt.failed {
+			t.Errorf("%s: failed is %v, want %v", tt.name, got, tt.failed)
+		}
+	}
+}
+
+// failTestSpans tags the spans of trace of ids with error=true
+func failTestSpans(trace *model.Trace, ids ...uint64) *model.Trace {
+	for _, span := range trace.Spans {
+		for _, id := range ids {
+			if span.SpanID == model.NewSpanID(id) {
+				span.Tags = append(span.Tags, model.Bool("error", true))
+			}
+		}
+	}
+	return trace
+}
+
+func sampledTestTrace(trace *model.Trace, probability float64) *model.Trace {
+	trace.Spans[0].Tags = append(trace.Spans[0].Tags,
+		model.String(samplerTypeKey, SamplerProbabilistic), model.Float64(samplerParamKey, probability))
+	return trace
+}
+
+// errorTestTraces are 4 requests of frontend calling backend twice, of which
+// 1. succeed,
+// 2. fail in the second call, which the root recovers from,
+// 3. fail in the root and
+// 4. fail in both calls and the root, sampled with probability 0.5
+func errorTestTraces() []*model.Trace {
+	request := func() *model.Trace {
+		return buildTestTrace(
+			testSpan{1, "frontend", 0, 10, nil},
+			testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}},
+			testSpan{3, "backend", 5, 3, []model.SpanRef{childOf(1)}},
+		)
+	}
+	return []*model.Trace{
+		request(),
+		failTestSpans(request(), 3),
+		failTestSpans(request(), 1),
+		sampledTestTrace(failTestSpans(request(), 1, 2, 3), 0.5),
+	}
+}
+
+func checkErrorRate(t *testing.T, name string, rate *ErrorRate, spans, errors int, weight, errorWeight float64) {
+	t.Helper()
+	if rate == nil {
+		t.Fatalf("no error rate of %s", name)
+	}
+	if rate.Spans != spans || rate.Errors != errors || rate.Weight != weight || rate.ErrorWeight != errorWeight {
+		t.Errorf("error rate of %s is %d of %d spans, weighted %v of %v, want %d of %d, weighted %v of %v",
+			name, rate.Errors, rate.Spans, rate.ErrorWeight, rate.Weight, errors, spans, errorWeight, weight)
+	}
+}
+
+func TestGetErrorRates(t *testing.T) {
+	rates := GetErrorRates(errorTestTraces(), AnalysisOptions{})
+
+	checkErrorRate(t, "frontend", rates.ByService["frontend"], 4, 2, 5, 3)
+	checkErrorRate(t, "backend", rates.ByService["backend"], 8, 3, 10, 5)
+	checkErrorRate(t, "frontend:op", rates.GetOperation("frontend", "op"), 4, 2, 5, 3)
+	checkErrorRate(t, "backend pod", rates.ByPod["backend-0"], 8, 3, 10, 5)
+	if rate := rates.GetOperation("frontend", "missing"); rate.Spans != 0 || rate.Rate() != 0 {
+		t.Errorf("error rate of a missing operation is %+v", rate)
+	}
+	if got := rates.ByService["frontend"].Rate(); got != 0.6 {
+		t.Errorf("weighted rate of frontend = %v, want 0.6", got)
+	}
+	// (sum w)^2 / sum w^2 = 25 / 7
+	if got := rates.ByService["frontend"].EffectiveSampleSize(); math.Abs(got-25.0/7) > 1e-9 {
+		t.Errorf("effective sample size of frontend = %v, want %v", got, 25.0/7)
+	}
+}
+
+func TestGetRequestErrorRates(t *testing.T) {
+	rates := GetRequestErrorRates(errorTestTraces(), AnalysisOptions{})
+	if len(rates) != 1 {
+		t.Fatalf("error rates are %v", rates)
+	}
+	rate := rates[OperationKey{Service: "frontend", Operation: "op"}]
+	// the second request was answered successfully
+	checkErrorRate(t, "requests", &rate.ErrorRate, 4, 2, 5, 3)
+	checkErrorRate(t, "requests with a failed span", &rate.AnySpan, 4, 3, 5, 4)
+	if rate.Rate() != 0.6 || rate.AnySpan.Rate() != 0.8 {
+		t.Errorf("rates are %v and %v of any span, want 0.6 and 0.8", rate.Rate(), rate.AnySpan.Rate())
+	}
+
+	// a trace without its root is not a request of any operation
+	orphan := buildTestTrace(testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}})
+	if rates := GetRequestErrorRates([]*model.Trace{orphan}, AnalysisOptions{}); len(rates) != 0 {
+		t.Errorf("error rates of an orphan are %v", rates)
+	}
+}
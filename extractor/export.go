@@ -0,0 +1,436 @@
+package extractor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// exportVersion is bumped on incompatible changes of the JSON schema.
+// 2: pods may be unresolved
+// 3: histogram bucket counts are sums of sampling weights
+const exportVersion = 3
+
+const (
+	dotCriticalColor = "red"
+	dotEdgeColor     = "gray40"
+	dotExtraColor    = "gray70"
+)
+
+// dotFillColor shades from white to red as ratio goes from 0 to 1
+func dotFillColor(ratio float64) string {
+	if ratio < 0 {
+		ratio = 0
+	} else if ratio > 1 {
+		ratio = 1
+	}
+	return strconv.Quote(fmt.Sprintf("0.000 %.3f 1.000", ratio))
+}
+
+func dotNodeID(sp *Span) string {
+	if sp.synthetic {
+		return "root"
+	}
+	return "s" + sp.spanID.String()
+}
+
+func dotEdgeStyle(kind EdgeKind) string {
+	if kind == FollowsFromEdge {
+		return "dashed"
+	}
+	return "solid"
+}
+
+// WriteDOT writes the span tree in Graphviz DOT, spans are filled darker the more self time they have,
+// and the spans and edges of the critical path are outlined in red
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", strconv.Quote(g.traceID.String()))
+	fmt.Fprintln(bw, "  node [shape=box, style=filled, fontname=Helvetica];")
+
+	critical := g.criticalPath.contributionBySpan()
+	var maxSelfTime time.Duration
+	g.walk(func(sp *Span) {
+		if sp.selfTime > maxSelfTime {
+			maxSelfTime = sp.selfTime
+		}
+	})
+
+	if g.root != nil {
+		doPrint(g.root, 0, func(_ int, sp *Span) {
+			if sp.synthetic {
+				fmt.Fprintf(bw, "  %s [label=\"<root>\", fillcolor=white, style=dashed];\n", dotNodeID(sp))
+				return
+			}
+			ratio := 0.0
+			if maxSelfTime > 0 {
+				ratio = float64(sp.selfTime) / float64(maxSelfTime)
+			}
+			pod := sp.podName
+			if !sp.podResolved {
+				pod = "<unresolved pod>"
+			}
+			label := fmt.Sprintf("%s\n%s\n%s\nduration: %v\nself: %v", sp.serviceName, sp.operationName, pod, sp.duration, sp.selfTime)
+			attrs := fmt.Sprintf("label=%s, fillcolor=%s", strconv.Quote(label), dotFillColor(ratio))
+			if contribution, ok := critical[sp]; ok {
+				attrs += fmt.Sprintf(", color=%s, penwidth=3, tooltip=%s", dotCriticalColor, strconv.Quote(fmt.Sprintf("critical: %v", contribution)))
+			}
+			fmt.Fprintf(bw, "  %s [%s];\n", dotNodeID(sp), attrs)
+		})
+		doPrint(g.root, 0, func(_ int, sp *Span) {
+			if sp.parent == nil {
+				return
+			}
+			_, parentCritical := critical[sp.parent]
+			_, childCritical := critical[sp]
+			color, width := dotEdgeColor, 1
+			if (parentCritical || sp.parent.synthetic) && childCritical && sp.kind == ChildOfEdge {
+				color, width = dotCriticalColor, 3
+			}
+			fmt.Fprintf(bw, "  %s -> %s [style=%s, color=%s, penwidth=%d];\n",
+				dotNodeID(sp.parent), dotNodeID(sp), dotEdgeStyle(sp.kind), color, width)
+		})
+	}
+	// references of multi-parent spans not used in the tree
+	for _, edge := range g.edges {
+		if edge.Child.parent != edge.Parent {
+			fmt.Fprintf(bw, "  %s -> %s [style=dotted, color=%s];\n", dotNodeID(edge.Parent), dotNodeID(edge.Child), dotExtraColor)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// JSON schema of a trace graph, durations are in microseconds and times relative to the start of the trace
+
+type GraphJSON struct {
+	Version      int               `json:"version"`
+	TraceID      string            `json:"traceID"`
+	StartTime    int64             `json:"startTime"` // unix microseconds
+	Duration     int64             `json:"duration"`
+	Completeness CompletenessJSON  `json:"completeness"`
+	Spans        []SpanJSON        `json:"spans"`
+	Edges        []EdgeJSON        `json:"edges"`
+	CriticalPath []PathSegmentJSON `json:"criticalPath"`
+}
+
+type CompletenessJSON struct {
+	Complete         bool    `json:"complete"`
+	Ratio            float64 `json:"ratio"`
+	NumSpans         int     `json:"numSpans"`
+	NumRoots         int     `json:"numRoots"`
+	NumConnected     int     `json:"numConnected"`
+	NumOrphans       int     `json:"numOrphans"`
+	MissingParents   int     `json:"missingParents"`
+	MultiParentSpans int     `json:"multiParentSpans"`
+	FollowsFromEdges int     `json:"followsFromEdges"`
+	DuplicateSpans   int     `json:"duplicateSpans"`
+	CyclicSpans      int     `json:"cyclicSpans"`
+	UnresolvedPods   int     `json:"unresolvedPods"`
+	SyntheticRoot    bool    `json:"syntheticRoot"`
+}
+
+type SpanJSON struct {
+	SpanID string `json:"spanID"`
+	// empty for the roots
+	ParentSpanID string `json:"parentSpanID"`
+	Kind         string `json:"kind"`
+	Service      string `json:"service"`
+	Operation    string `json:"operation"`
+	// empty if the pod could not be resolved
+	Pod       string `json:"pod"`
+	StartTime int64  `json:"startTime"`
+	Duration  int64  `json:"duration"`
+	SelfTime  int64  `json:"selfTime"`
+	// CriticalTime is what the span contributed to the critical path, 0 off the path
+	CriticalTime int64 `json:"criticalTime"`
+}
+
+type EdgeJSON struct {
+	Parent string `json:"parent"`
+	Child  string `json:"child"`
+	Kind   string `json:"kind"`
+	// false for the extra references of multi-parent spans
+	Tree bool `json:"tree"`
+}
+
+type PathSegmentJSON struct {
+	SpanID       string `json:"spanID"`
+	StartTime    int64  `json:"startTime"`
+	Contribution int64  `json:"contribution"`
+}
+
+func micros(d time.Duration) int64 {
+	return d.Microseconds()
+}
+
+// ToJSON converts the graph into the exported schema, spans are in the order they started
+func (g *Graph) ToJSON() *GraphJSON {
+	c := g.completeness
+	out := &GraphJSON{
+		Version:   exportVersion,
+		TraceID:   g.traceID.String(),
+		StartTime: g.startTime.UnixNano() / int64(time.Microsecond),
+		Completeness: CompletenessJSON{
+			Complete:         c.Complete(),
+			Ratio:            c.Ratio(),
+			NumSpans:         c.NumSpans,
+			NumRoots:         c.NumRoots,
+			NumConnected:     c.NumConnected,
+			NumOrphans:       c.NumOrphans,
+			MissingParents:   c.MissingParents,
+			MultiParentSpans: c.MultiParentSpans,
+			FollowsFromEdges: c.FollowsFromEdges,
+			DuplicateSpans:   c.DuplicateSpans,
+			CyclicSpans:      c.CyclicSpans,
+			UnresolvedPods:   c.UnresolvedPods,
+			SyntheticRoot:    c.SyntheticRoot,
+		},
+		Spans:        make([]SpanJSON, 0, c.NumSpans),
+		Edges:        make([]EdgeJSON, 0, len(g.edges)),
+		CriticalPath: make([]PathSegmentJSON, 0),
+	}
+	if g.root != nil {
+		out.Duration = micros(g.root.duration)
+	}
+
+	critical := g.criticalPath.contributionBySpan()
+	g.walk(func(sp *Span) {
+		s := SpanJSON{
+			SpanID:       sp.spanID.String(),
+			Kind:         sp.kind.String(),
+			Service:      sp.serviceName,
+			Operation:    sp.operationName,
+			Pod:          sp.podName,
+			StartTime:    micros(sp.startTime),
+			Duration:     micros(sp.duration),
+			SelfTime:     micros(sp.selfTime),
+			CriticalTime: micros(critical[sp]),
+		}
+		if sp.parent != nil && !sp.parent.synthetic {
+			s.ParentSpanID = sp.parent.spanID.String()
+		}
+		out.Spans = append(out.Spans, s)
+	})
+	sort.SliceStable(out.Spans, func(i, j int) bool {
+		return out.Spans[i].StartTime < out.Spans[j].StartTime
+	})
+	for _, edge := range g.edges {
+		out.Edges = append(out.Edges, EdgeJSON{
+			Parent: edge.Parent.spanID.String(),
+			Child:  edge.Child.spanID.String(),
+			Kind:   edge.Kind.String(),
+			Tree:   edge.Child.parent == edge.Parent,
+		})
+	}
+	for curr := g.criticalPath.head; curr != nil; curr = curr.next {
+		out.CriticalPath = append(out.CriticalPath, PathSegmentJSON{
+			SpanID:       curr.span.spanID.String(),
+			StartTime:    micros(curr.start),
+			Contribution: micros(curr.GetContribution()),
+		})
+	}
+	return out
+}
+
+func (g *Graph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g.ToJSON())
+}
+
+// WriteDOT writes the service level graph in Graphviz DOT, services are filled darker the more self time
+// they have at p50, and outlined in red by the time they contributed to critical paths; edges are labelled
+// with their calls per request and drawn thicker the more often they are on a critical path
+func (sg *ServiceGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph services {")
+	fmt.Fprintln(bw, "  node [shape=box, style=filled, fontname=Helvetica];")
+
+	services := sg.GetServices()
+	var maxSelfTime, maxCriticalTime time.Duration
+	for _, node := range services {
+		if p50 := node.SelfTime.Quantile(0.5); p50 > maxSelfTime {
+			maxSelfTime = p50
+		}
+		if node.CriticalTime > maxCriticalTime {
+			maxCriticalTime = node.CriticalTime
+		}
+	}
+	for _, node := range services {
+		ratio := 0.0
+		if maxSelfTime > 0 {
+			ratio = float64(node.SelfTime.Quantile(0.5)) / float64(maxSelfTime)
+		}
+		label := fmt.Sprintf("%s\nspans: %d\nself p50: %v\nself p99: %v", node.Name, node.Spans,
+			node.SelfTime.Quantile(0.5), node.SelfTime.Quantile(0.99))
+		attrs := fmt.Sprintf("label=%s, fillcolor=%s", strconv.Quote(label), dotFillColor(ratio))
+		if node.CriticalTime > 0 && maxCriticalTime > 0 {
+			attrs += fmt.Sprintf(", color=%s, penwidth=%.1f", dotCriticalColor, 1+4*float64(node.CriticalTime)/float64(maxCriticalTime))
+		}
+		fmt.Fprintf(bw, "  %s [%s];\n", strconv.Quote(node.Name), attrs)
+	}
+	for _, edge := range sg.GetEdges() {
+		label := fmt.Sprintf("%d calls\n%.2f per request\np99: %v\ngap p50: %v", edge.Calls, edge.MeanMultiplicity(),
+			edge.Latency.Quantile(0.99), edge.Gap.Quantile(0.5))
+		color, width := dotEdgeColor, 1.0
+		if edge.CriticalCalls > 0 {
+			color, width = dotCriticalColor, 1+4*float64(edge.CriticalCalls)/float64(edge.Calls)
+		}
+		fmt.Fprintf(bw, "  %s -> %s [label=%s, style=%s, color=%s, penwidth=%.1f];\n", strconv.Quote(edge.From), strconv.Quote(edge.To),
+			strconv.Quote(label), dotEdgeStyle(edge.Kind), color, width)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// JSON schema of a service graph, durations are in microseconds
+
+type ServiceGraphJSON struct {
+	Version   int                  `json:"version"`
+	NumTraces int                  `json:"numTraces"`
+	Services  []ServiceNodeJSON    `json:"services"`
+	Pods      []ServiceNodeJSON    `json:"pods"`
+	Edges     []ServiceEdgeJSON    `json:"edges"`
+	PodEdges  []ServiceEdgeJSON    `json:"podEdges"`
+	Entries   map[string]EntryJSON `json:"entries"`
+	// number of spans of each service whose pod could not be resolved
+	UnresolvedSpans map[string]int `json:"unresolvedSpans"`
+}
+
+type EntryJSON struct {
+	Requests int               `json:"requests"`
+	Edges    []ServiceEdgeJSON `json:"edges"`
+}
+
+type ServiceNodeJSON struct {
+	Name         string        `json:"name"`
+	Service      string        `json:"service"`
+	Spans        int           `json:"spans"`
+	Latency      HistogramJSON `json:"latency"`
+	SelfTime     HistogramJSON `json:"selfTime"`
+	CriticalTime int64         `json:"criticalTime"`
+}
+
+type ServiceEdgeJSON struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Kind          string `json:"kind"`
+	Calls         int    `json:"calls"`
+	Requests      int    `json:"requests"`
+	CriticalCalls int    `json:"criticalCalls"`
+	// Multiplicity maps a number of calls in one request, as a string, to the number of requests making that many
+	Multiplicity map[string]int `json:"multiplicity"`
+	Latency      HistogramJSON  `json:"latency"`
+	StartGap     HistogramJSON  `json:"startGap"`
+	EndGap       HistogramJSON  `json:"endGap"`
+	Gap          HistogramJSON  `json:"gap"`
+	Skewed       int            `json:"skewed"`
+}
+
+type HistogramJSON struct {
+	Count int `json:"count"`
+	// Weight is the count corrected for sampling, EffectiveSamples how many unweighted values it is worth
+	Weight           float64               `json:"weight"`
+	EffectiveSamples float64               `json:"effectiveSamples"`
+	Mean             int64                 `json:"mean"`
+	P50              int64                 `json:"p50"`
+	P90              int64                 `json:"p90"`
+	P99              int64                 `json:"p99"`
+	Max              int64                 `json:"max"`
+	Buckets          []HistogramBucketJSON `json:"buckets"`
+}
+
+type HistogramBucketJSON struct {
+	UpperBound int64 `json:"le"`
+	// sum of the sampling weights of the values in the bucket
+	Count float64 `json:"count"`
+}
+
+func histogramToJSON(h *Sketch) HistogramJSON {
+	out := HistogramJSON{
+		Count:            h.Count(),
+		Weight:           h.Weight(),
+		EffectiveSamples: h.EffectiveSampleSize(),
+		Mean:             micros(h.Mean()),
+		P50:              micros(h.Quantile(0.5)),
+		P90:              micros(h.Quantile(0.9)),
+		P99:              micros(h.Quantile(0.99)),
+		Max:              micros(h.Quantile(1)),
+		Buckets:          make([]HistogramBucketJSON, 0),
+	}
+	for _, bucket := range h.Buckets() {
+		out.Buckets = append(out.Buckets, HistogramBucketJSON{UpperBound: micros(bucket.UpperBound), Count: bucket.Weight})
+	}
+	return out
+}
+
+func serviceNodesToJSON(nodes []*ServiceNode) []ServiceNodeJSON {
+	out := make([]ServiceNodeJSON, 0, len(nodes))
+	for _, node := range nodes {
+		out = append(out, ServiceNodeJSON{
+			Name:         node.Name,
+			Service:      node.Service,
+			Spans:        node.Spans,
+			Latency:      histogramToJSON(node.Latency),
+			SelfTime:     histogramToJSON(node.SelfTime),
+			CriticalTime: micros(node.CriticalTime),
+		})
+	}
+	return out
+}
+
+func serviceEdgesToJSON(edges []*ServiceEdge) []ServiceEdgeJSON {
+	out := make([]ServiceEdgeJSON, 0, len(edges))
+	for _, edge := range edges {
+		multiplicity := make(map[string]int, len(edge.Multiplicity))
+		for calls, requests := range edge.Multiplicity {
+			multiplicity[strconv.Itoa(calls)] = requests
+		}
+		out = append(out, ServiceEdgeJSON{
+			From:          edge.From,
+			To:            edge.To,
+			Kind:          edge.Kind.String(),
+			Calls:         edge.Calls,
+			Requests:      edge.Requests,
+			CriticalCalls: edge.CriticalCalls,
+			Multiplicity:  multiplicity,
+			Latency:       histogramToJSON(edge.Latency),
+			StartGap:      histogramToJSON(edge.StartGap),
+			EndGap:        histogramToJSON(edge.EndGap),
+			Gap:           histogramToJSON(edge.Gap),
+			Skewed:        edge.Skewed,
+		})
+	}
+	return out
+}
+
+func (sg *ServiceGraph) ToJSON() *ServiceGraphJSON {
+	out := &ServiceGraphJSON{
+		Version:         exportVersion,
+		NumTraces:       sg.numTraces,
+		Services:        serviceNodesToJSON(sg.GetServices()),
+		Pods:            serviceNodesToJSON(sg.GetPods()),
+		Edges:           serviceEdgesToJSON(sg.GetEdges()),
+		PodEdges:        serviceEdgesToJSON(sg.GetPodEdges()),
+		Entries:         make(map[string]EntryJSON, len(sg.entries)),
+		UnresolvedSpans: sg.unresolved,
+	}
+	for _, op := range sg.GetEntryOperations() {
+		out.Entries[op] = EntryJSON{
+			Requests: sg.NumRequests(op),
+			Edges:    serviceEdgesToJSON(sg.FanOut(op)),
+		}
+	}
+	return out
+}
+
+func (sg *ServiceGraph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sg.ToJSON())
+}
@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"os"
 	"path"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
@@ -28,6 +33,18 @@ const (
 	defaultNumTraces = 100
 	sizeOfTraceID    = 16
 	encodingTypeBits = 0x0F
+
+	defaultFetchConcurrency = 8
+	defaultFetchBatchSize   = 64
+	defaultCacheSize        = 10000
+	defaultIndexCacheTTL    = 30 * time.Second
+)
+
+var (
+	ErrServiceNameNotSet         = errors.New("service name must be set to query operation names or tags")
+	ErrStartTimeAfterEndTime     = errors.New("start time of the query is after its end time")
+	ErrDurationMinGreaterThanMax = errors.New("min duration of the query is greater than max duration")
+	ErrStoreLocked               = errors.New("badger store is locked by another process")
 )
 
 // primary key:
@@ -60,31 +77,176 @@ const (
 //			 for _, kv := range log.Fields;
 
 type TraceReader struct {
+	// store is swapped when the snapshot is refreshed
+	mu    sync.RWMutex
 	store *badger.DB
+
+	path        string
+	options     ReaderOptions
+	snapshotDir string
+	// when the copy of the current snapshot started, zero without snapshot
+	snapshotTime time.Time
+	done         chan struct{}
+	closeOnce    sync.Once
+	// nil if disabled
+	cache *traceCache
+	// services and operations
+	indexes *indexCache
+}
+
+type ReaderOptions struct {
+	// ReadOnly opens the store without writing to it, e.g. a store Jaeger has stopped writing to.
+	// Badger refuses it while another process holds the store for writing.
+	ReadOnly bool
+	// SnapshotDir is a scratch directory the store is copied to before it is read,
+	// so the store of a running Jaeger is never opened
+	SnapshotDir string
+	// SnapshotInterval refreshes the snapshot periodically if > 0
+	SnapshotInterval time.Duration
+	// Concurrency is the number of workers of GetTraces, BatchSize the number of traces each reads per transaction
+	Concurrency int
+	BatchSize   int
+	// CacheSize is the number of decoded traces kept, 0 for defaultCacheSize and < 0 to disable the cache
+	CacheSize int
 }
 
-func NewTraceReader(p string) *TraceReader {
+func NewTraceReader(p string, options ReaderOptions) (*TraceReader, error) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultFetchConcurrency
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultFetchBatchSize
+	}
+	if options.CacheSize == 0 {
+		options.CacheSize = defaultCacheSize
+	}
+	tr := &TraceReader{
+		path:    p,
+		options: options,
+		done:    make(chan struct{}),
+		indexes: newIndexCache(defaultIndexCacheTTL),
+	}
+	if options.CacheSize > 0 {
+		tr.cache = newTraceCache(options.CacheSize)
+	}
+
+	if options.SnapshotDir == "" {
+		db, err := openStore(p, options.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		tr.store = db
+		return tr, nil
+	}
+
+	snapshotTime := time.Now()
+	db, dir, err := openSnapshot(p, options.SnapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	tr.store = db
+	tr.snapshotDir = dir
+	tr.snapshotTime = snapshotTime
+	if options.SnapshotInterval > 0 {
+		go tr.refreshSnapshot()
+	}
+	return tr, nil
+}
+
+func openStore(p string, readOnly bool) (*badger.DB, error) {
 	dir := path.Join(p, "key")
 	valueDir := path.Join(p, "data")
-	options := badger.DefaultOptions("").WithDir(dir).WithValueDir(valueDir)
+	options := badger.DefaultOptions("").WithDir(dir).WithValueDir(valueDir).WithReadOnly(readOnly)
 	db, err := badger.Open(options)
 	if err != nil {
-		log.Fatal(err)
+		// badger formats the error of flock into its own, which leaves nothing to match but the message
+		if strings.Contains(err.Error(), "Cannot acquire directory lock") {
+			return nil, fmt.Errorf("%w: %s, read it from a snapshot instead: %v", ErrStoreLocked, p, err)
+		}
+		return nil, fmt.Errorf("failed to open badger store %s: %w", p, err)
 	}
-	return &TraceReader{
-		store: db,
+	return db, nil
+}
+
+func (tr *TraceReader) refreshSnapshot() {
+	ticker := time.NewTicker(tr.options.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tr.done:
+			return
+		case <-ticker.C:
+		}
+
+		snapshotTime := time.Now()
+		db, dir, err := openSnapshot(tr.path, tr.options.SnapshotDir)
+		if err != nil {
+			log.Printf("failed to refresh snapshot of %s: %v", tr.path, err)
+			continue
+		}
+
+		tr.mu.Lock()
+		select {
+		case <-tr.done:
+			// closed while copying
+			tr.mu.Unlock()
+			db.Close()
+			os.RemoveAll(dir)
+			return
+		default:
+		}
+		oldStore, oldDir := tr.store, tr.snapshotDir
+		tr.store, tr.snapshotDir, tr.snapshotTime = db, dir, snapshotTime
+		tr.mu.Unlock()
+
+		oldStore.Close()
+		os.RemoveAll(oldDir)
 	}
 }
 
+// view runs fn in a read transaction of the current store
+func (tr *TraceReader) view(fn func(txn *badger.Txn) error) error {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	return tr.store.View(fn)
+}
+
+// readTime returns the time the store is read as of: when the copy of the snapshot started, now without snapshot
+func (tr *TraceReader) readTime() time.Time {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	if tr.snapshotDir == "" {
+		return time.Now()
+	}
+	return tr.snapshotTime
+}
+
+// Close closes the store and removes the snapshot, only the first call does
 func (tr *TraceReader) Close() {
-	tr.store.Close()
+	tr.closeOnce.Do(func() {
+		close(tr.done)
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		tr.store.Close()
+		if tr.cache != nil {
+			tr.cache.purge()
+		}
+		tr.indexes.purge()
+		if tr.snapshotDir != "" {
+			os.RemoveAll(tr.snapshotDir)
+		}
+	})
 }
 
 type Query struct {
-	serviceName string
-	startTime   time.Time
-	endTime     time.Time
-	numTraces   int
+	serviceName   string
+	operationName string
+	tags          map[string]string
+	durationMin   time.Duration
+	durationMax   time.Duration
+	startTime     time.Time
+	endTime       time.Time
+	numTraces     int
 }
 
 func NewQuery(serviceName string, startTime, endTime time.Time, numTraces int) *Query {
@@ -96,6 +258,60 @@ func NewQuery(serviceName string, startTime, endTime time.Time, numTraces int) *
 	}
 }
 
+// WithOperationName only keeps traces having a span of operationName in the service of the query
+func (q *Query) WithOperationName(operationName string) *Query {
+	q.operationName = operationName
+	return q
+}
+
+// WithTags only keeps traces having spans of the service with all the tags, e.g. error=true
+func (q *Query) WithTags(tags map[string]string) *Query {
+	q.tags = tags
+	return q
+}
+
+// WithDuration only keeps traces having a span whose duration is in [min, max], 0 means unbounded
+func (q *Query) WithDuration(min, max time.Duration) *Query {
+	q.durationMin = min
+	q.durationMax = max
+	return q
+}
+
+func (q *Query) validate() error {
+	if q.serviceName == "" && (q.operationName != "" || len(q.tags) > 0) {
+		return ErrServiceNameNotSet
+	}
+	if q.endTime.Before(q.startTime) {
+		return ErrStartTimeAfterEndTime
+	}
+	if q.durationMin != 0 && q.durationMax != 0 && q.durationMin > q.durationMax {
+		return ErrDurationMinGreaterThanMax
+	}
+	return nil
+}
+
+// indexSeeks returns the index prefixes to intersect, as jaeger's badger reader does.
+// The last one is scanned in the end, it decides the order of the results.
+func (q *Query) indexSeeks() [][]byte {
+	indexSeeks := make([][]byte, 0, len(q.tags)+1)
+	for k, v := range q.tags {
+		tagSearchKey := make([]byte, 0)
+		tagSearchKey = append(tagSearchKey, tagIndexKey)
+		tagSearchKey = append(tagSearchKey, []byte(q.serviceName+k+v)...)
+		indexSeeks = append(indexSeeks, tagSearchKey)
+	}
+
+	index := make([]byte, 0)
+	if q.operationName != "" {
+		index = append(index, operationNameIndexKey)
+		index = append(index, []byte(q.serviceName+q.operationName)...)
+	} else {
+		index = append(index, serviceNameIndexKey)
+		index = append(index, []byte(q.serviceName)...)
+	}
+	return append(indexSeeks, index)
+}
+
 func timeAsEpochMicroseconds(t time.Time) uint64 {
 	return uint64(t.UnixNano() / 1000)
 }
@@ -107,8 +323,11 @@ func bytesToTraceID(key []byte) model.TraceID {
 	}
 }
 
-// QueryTimeRange this function only use primary key to scan all the tables
+// QueryTimeRange only scans the secondary indexes, spans outside the time range are never touched
 func (tr *TraceReader) QueryTimeRange(query *Query) ([]model.TraceID, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
 	if query.serviceName == "" {
 		return tr.queryWithoutServiceName(query)
 	} else {
@@ -116,6 +335,8 @@ func (tr *TraceReader) QueryTimeRange(query *Query) ([]model.TraceID, error) {
 	}
 }
 
+// queryWithoutServiceName fans out over serviceNameIndexKey. Every span has exactly one key there,
+// so seeking to startTime of each service only touches the spans inside the time range
 func (tr *TraceReader) queryWithoutServiceName(query *Query) ([]model.TraceID, error) {
 	minTimeStamp := make([]byte, 8)
 	binary.BigEndian.PutUint64(minTimeStamp, timeAsEpochMicroseconds(query.startTime))
@@ -123,48 +344,198 @@ func (tr *TraceReader) queryWithoutServiceName(query *Query) ([]model.TraceID, e
 	maxTimeStamp := make([]byte, 8)
 	binary.BigEndian.PutUint64(maxTimeStamp, timeAsEpochMicroseconds(query.endTime))
 
-	traceKeys := make([][]byte, 0)
+	// the earliest startTime inside the range of each trace
+	startTimes := make(map[model.TraceID]uint64)
+
+	var durationFilter map[model.TraceID]struct{}
+	err := tr.view(func(txn *badger.Txn) error {
+		if query.durationMin != 0 || query.durationMax != 0 {
+			durationFilter = scanDurationIndex(txn, query.durationMin, query.durationMax, minTimeStamp, maxTimeStamp)
+		}
+		services := scanServiceNames(txn)
 
-	err := tr.store.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		startIndex := []byte{spanKeyPrefix}
-		var prevTraceID []byte
-		for it.Seek(startIndex); it.ValidForPrefix(startIndex); it.Next() {
-			item := it.Item()
-			key := item.KeyCopy(nil)
-
-			timestamp := key[sizeOfTraceID+1 : sizeOfTraceID+1+8]
-			traceID := key[1 : sizeOfTraceID+1]
+		for _, service := range services {
+			index := make([]byte, 0, 1+len(service)+8)
+			index = append(index, serviceNameIndexKey)
+			index = append(index, []byte(service)...)
+			startIndex := append(index, minTimeStamp...)
+
+			for it.Seek(startIndex); it.ValidForPrefix(index); it.Next() {
+				key := it.Item().Key()
+				// keys of another service sharing the prefix (service1 & service12) come after ours
+				if len(key) != len(index)+8+sizeOfTraceID {
+					break
+				}
+				timestamp := key[len(index) : len(index)+8]
+				if bytes.Compare(timestamp, maxTimeStamp) > 0 {
+					break
+				}
 
-			if bytes.Compare(timestamp, minTimeStamp) >= 0 && bytes.Compare(timestamp, maxTimeStamp) <= 0 {
-				if !bytes.Equal(traceID, prevTraceID) {
-					traceKeys = append(traceKeys, key)
-					prevTraceID = traceID
+				traceID := bytesToTraceID(key[len(index)+8:])
+				startTime := binary.BigEndian.Uint64(timestamp)
+				if prev, ok := startTimes[traceID]; !ok || startTime < prev {
+					startTimes[traceID] = startTime
 				}
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	sort.Slice(traceKeys, func(k, h int) bool {
+	traceIDs := make([]model.TraceID, 0, len(startTimes))
+	for traceID := range startTimes {
+		if durationFilter != nil {
+			if _, ok := durationFilter[traceID]; !ok {
+				continue
+			}
+		}
+		traceIDs = append(traceIDs, traceID)
+	}
+	sort.Slice(traceIDs, func(k, h int) bool {
 		// This sorts by timestamp to descending order
-		return bytes.Compare(traceKeys[k][sizeOfTraceID+1:sizeOfTraceID+1+8], traceKeys[h][sizeOfTraceID+1:sizeOfTraceID+1+8]) > 0
+		return startTimes[traceIDs[k]] > startTimes[traceIDs[h]]
 	})
 
-	sizeCount := len(traceKeys)
-	if query.numTraces > 0 && query.numTraces < sizeCount {
-		sizeCount = query.numTraces
+	if query.numTraces > 0 && query.numTraces < len(traceIDs) {
+		traceIDs = traceIDs[:query.numTraces]
 	}
+	return traceIDs, nil
+}
+
+// GetServices lists the services having spans in the store, cached for defaultIndexCacheTTL.
+// The returned slice is shared and must not be modified.
+func (tr *TraceReader) GetServices() ([]string, error) {
+	return tr.indexes.get([]byte{serviceNameIndexKey}, func() ([]string, error) {
+		var services []string
+		err := tr.view(func(txn *badger.Txn) error {
+			services = scanServiceNames(txn)
+			return nil
+		})
+		return services, err
+	})
+}
+
+// GetOperations lists the operations of service, cached for defaultIndexCacheTTL. The index keys concatenate
+// the service and operation names, so when a longer service shares the prefix (service1 & service12) an operation
+// is only kept if a span of service with it is in the store. The returned slice is shared and must not be modified.
+func (tr *TraceReader) GetOperations(service string) ([]string, error) {
+	prefix := append([]byte{operationNameIndexKey}, []byte(service)...)
+	return tr.indexes.get(prefix, func() ([]string, error) {
+		operations := make([]string, 0)
+		err := tr.view(func(txn *badger.Txn) error {
+			services := scanServiceNames(txn)
+			for _, operation := range scanIndexValues(txn, prefix) {
+				ambiguous := false
+				for _, other := range services {
+					if len(other) > len(service) && strings.HasPrefix(service+operation, other) {
+						ambiguous = true
+						break
+					}
+				}
+				if ambiguous {
+					found, err := hasOperationSpan(txn, service, operation)
+					if err != nil {
+						return err
+					}
+					if !found {
+						continue
+					}
+				}
+				operations = append(operations, operation)
+			}
+			return nil
+		})
+		return operations, err
+	})
+}
 
-	traceIDs := make([]model.TraceID, sizeCount)
-	for i := 0; i < sizeCount; i++ {
-		traceIDs[i] = bytesToTraceID(traceKeys[i][1 : sizeOfTraceID+1])
+// hasOperationSpan tells if the operation index keys of service+operation are those of service with operation. The keys are
+// shared with the other services whose name and operation concatenate the same, so the span of the first key is decoded:
+// a span concatenating the same decides, whether it is of service or of another service. Only when the trace has no such span
+// at the time of the key, e.g. it expired, the next key is tried.
+func hasOperationSpan(txn *badger.Txn, service, operation string) (bool, error) {
+	index := append([]byte{operationNameIndexKey}, []byte(service+operation)...)
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	indexIt := txn.NewIterator(opts)
+	defer indexIt.Close()
+	spanIt := txn.NewIterator(opts)
+	defer spanIt.Close()
+
+	for indexIt.Seek(index); indexIt.ValidForPrefix(index); indexIt.Next() {
+		key := indexIt.Item().Key()
+		// keys of a longer value come after ours
+		if len(key) != len(index)+8+sizeOfTraceID {
+			continue
+		}
+		// the spans of the trace which started at the time of the key
+		traceID := bytesToTraceID(key[len(index)+8:])
+		spanPrefix := append(createPrimaryKeySeekPrefix(traceID), key[len(index):len(index)+8]...)
+		for spanIt.Seek(spanPrefix); spanIt.ValidForPrefix(spanPrefix); spanIt.Next() {
+			item := spanIt.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return false, fmt.Errorf("failed to read span of trace %s: %w", traceID, err)
+			}
+			sp, err := decodeValue(val, item.UserMeta()&encodingTypeBits)
+			if err != nil {
+				return false, err
+			}
+			if sp.Process != nil && sp.Process.ServiceName+sp.OperationName == service+operation {
+				return sp.Process.ServiceName == service, nil
+			}
+		}
 	}
-	return traceIDs, err
+	return false, nil
+}
+
+// scanServiceNames lists the services of serviceNameIndexKey
+func scanServiceNames(txn *badger.Txn) []string {
+	return scanIndexValues(txn, []byte{serviceNameIndexKey})
+}
+
+// scanIndexValues lists the values of the index keys starting with prefix, without prefix. Instead of visiting every key it
+// seeks past the timestamps of the current value, so the cost is about one seek per value.
+func scanIndexValues(txn *badger.Txn, prefix []byte) []string {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	seen := make(map[string]struct{})
+	for it.Seek(prefix); it.ValidForPrefix(prefix); {
+		key := it.Item().Key()
+		timestampStartIndex := len(key) - (sizeOfTraceID + 8) // timestamp is stored with 8 bytes
+		if timestampStartIndex < len(prefix) {
+			it.Next()
+			continue
+		}
+		seen[string(key[len(prefix):timestampStartIndex])] = struct{}{}
+		if key[timestampStartIndex] == 0xFF {
+			it.Next()
+			continue
+		}
+
+		next := make([]byte, timestampStartIndex+1)
+		copy(next, key[:timestampStartIndex])
+		next[timestampStartIndex] = key[timestampStartIndex] + 1
+		it.Seek(next)
+	}
+
+	values := make([]string, 0, len(seen))
+	for value := range seen {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
 }
 
 func scanFunction(it *badger.Iterator, indexPrefix []byte, timeBytesStart []byte, timeBytesEnd []byte) bool {
@@ -186,9 +557,7 @@ func scanFunction(it *badger.Iterator, indexPrefix []byte, timeBytesStart []byte
 }
 
 func (tr *TraceReader) queryWithServiceName(query *Query) ([]model.TraceID, error) {
-	index := make([]byte, 0)
-	index = append(index, serviceNameIndexKey)
-	index = append(index, []byte(query.serviceName)...)
+	indexSeeks := query.indexSeeks()
 
 	minTimeStamp := make([]byte, 8)
 	binary.BigEndian.PutUint64(minTimeStamp, timeAsEpochMicroseconds(query.startTime))
@@ -196,34 +565,48 @@ func (tr *TraceReader) queryWithServiceName(query *Query) ([]model.TraceID, erro
 	maxTimeStamp := make([]byte, 8)
 	binary.BigEndian.PutUint64(maxTimeStamp, timeAsEpochMicroseconds(query.endTime))
 
-	indexResults := make([][]byte, 0)
-	err := tr.store.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		// iterate from the latest to the oldest
-		opts.Reverse = true
+	traceIDs := make([]model.TraceID, 0)
+	err := tr.view(func(txn *badger.Txn) error {
+		var filter map[model.TraceID]struct{}
+		if query.durationMin != 0 || query.durationMax != 0 {
+			filter = scanDurationIndex(txn, query.durationMin, query.durationMax, minTimeStamp, maxTimeStamp)
+		}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+		// merge join all but the last index
+		var merged [][]byte
+		for i := 0; i < len(indexSeeks)-1; i++ {
+			indexResults := uniqueTraceIDs(scanIndexKeys(txn, indexSeeks[i], minTimeStamp, maxTimeStamp))
+			if merged == nil {
+				merged = indexResults
+			} else {
+				merged = mergeJoinIDs(merged, indexResults)
+			}
+		}
+		if merged != nil {
+			hashed := make(map[model.TraceID]struct{}, len(merged))
+			for _, traceID := range merged {
+				id := bytesToTraceID(traceID)
+				if _, ok := filter[id]; filter == nil || ok {
+					hashed[id] = struct{}{}
+				}
+			}
+			filter = hashed
+		}
 
-		// 8 bytes for timestamp; 1 byte for 0xFF
-		startIndex := make([]byte, len(index)+8+1)
-		startIndex[len(startIndex)-1] = 0xFF
-		copy(startIndex, index)
-		copy(startIndex[len(index):], maxTimeStamp)
-
-		// note: reverse = true
-		for it.Seek(startIndex); scanFunction(it, index, maxTimeStamp, minTimeStamp); it.Next() {
-			item := it.Item()
-
-			// ScanFunction is a prefix scanning (since we could have for example service1 & service12)
-			// Now we need to match only the exact key if we want to add it
-			timestampStartIndex := len(it.Item().Key()) - (sizeOfTraceID + 8) // timestamp is stored with 8 bytes
-			if bytes.Equal(index, it.Item().Key()[:timestampStartIndex]) {
-				traceIDBytes := item.Key()[len(item.Key())-sizeOfTraceID:]
-				traceIDCopy := make([]byte, sizeOfTraceID)
-				copy(traceIDCopy, traceIDBytes)
-				indexResults = append(indexResults, traceIDCopy)
+		// the last index is scanned from the latest to the oldest
+		seen := make(map[model.TraceID]struct{})
+		for _, traceID := range scanIndexKeys(txn, indexSeeks[len(indexSeeks)-1], minTimeStamp, maxTimeStamp) {
+			id := bytesToTraceID(traceID)
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			if _, ok := filter[id]; filter != nil && !ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			traceIDs = append(traceIDs, id)
+			if len(traceIDs) == query.numTraces {
+				break
 			}
 		}
 		return nil
@@ -231,22 +614,134 @@ func (tr *TraceReader) queryWithServiceName(query *Query) ([]model.TraceID, erro
 	if err != nil {
 		return nil, err
 	}
+	return traceIDs, nil
+}
+
+// scanIndexKeys returns the traceIDs of an index inside the time range, from the latest to the oldest
+func scanIndexKeys(txn *badger.Txn, index []byte, minTimeStamp, maxTimeStamp []byte) [][]byte {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	// iterate from the latest to the oldest
+	opts.Reverse = true
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	// 8 bytes for timestamp; 1 byte for 0xFF
+	startIndex := make([]byte, len(index)+8+1)
+	startIndex[len(startIndex)-1] = 0xFF
+	copy(startIndex, index)
+	copy(startIndex[len(index):], maxTimeStamp)
+
+	indexResults := make([][]byte, 0)
+	// note: reverse = true
+	for it.Seek(startIndex); scanFunction(it, index, maxTimeStamp, minTimeStamp); it.Next() {
+		item := it.Item()
+
+		// ScanFunction is a prefix scanning (since we could have for example service1 & service12)
+		// Now we need to match only the exact key if we want to add it
+		timestampStartIndex := len(it.Item().Key()) - (sizeOfTraceID + 8) // timestamp is stored with 8 bytes
+		if bytes.Equal(index, it.Item().Key()[:timestampStartIndex]) {
+			traceIDBytes := item.Key()[len(item.Key())-sizeOfTraceID:]
+			traceIDCopy := make([]byte, sizeOfTraceID)
+			copy(traceIDCopy, traceIDBytes)
+			indexResults = append(indexResults, traceIDCopy)
+		}
+	}
+	return indexResults
+}
+
+// scanDurationIndex returns the traces having a span inside the time range whose duration is in [min, max].
+// Keys are sorted by duration first, so it seeks to the time range of every duration on the way.
+func scanDurationIndex(txn *badger.Txn, min, max time.Duration, minTimeStamp, maxTimeStamp []byte) map[model.TraceID]struct{} {
+	durationMin := model.DurationAsMicroseconds(min)
+	durationMax := model.DurationAsMicroseconds(max)
+	if max == 0 {
+		durationMax = math.MaxUint64
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := []byte{durationIndexKey}
+	seekKey := make([]byte, 1+8+8)
+	seekKey[0] = durationIndexKey
+	binary.BigEndian.PutUint64(seekKey[1:], durationMin)
+	copy(seekKey[1+8:], minTimeStamp)
+
+	results := make(map[model.TraceID]struct{})
+	for it.Seek(seekKey); it.ValidForPrefix(prefix); {
+		key := it.Item().Key()
+		if len(key) != 1+8+8+sizeOfTraceID {
+			it.Next()
+			continue
+		}
+		duration := binary.BigEndian.Uint64(key[1 : 1+8])
+		if duration > durationMax {
+			break
+		}
+
+		timestamp := key[1+8 : 1+8+8]
+		if bytes.Compare(timestamp, minTimeStamp) < 0 {
+			binary.BigEndian.PutUint64(seekKey[1:], duration)
+			it.Seek(seekKey)
+			continue
+		}
+		if bytes.Compare(timestamp, maxTimeStamp) > 0 {
+			if duration == math.MaxUint64 {
+				break
+			}
+			binary.BigEndian.PutUint64(seekKey[1:], duration+1)
+			it.Seek(seekKey)
+			continue
+		}
+
+		results[bytesToTraceID(key[1+8+8:])] = struct{}{}
+		it.Next()
+	}
+	return results
+}
 
-	sort.Slice(indexResults, func(k, h int) bool {
-		return bytes.Compare(indexResults[k], indexResults[h]) < 0
+// uniqueTraceIDs sorts traceIDs and removes the duplications
+func uniqueTraceIDs(traceIDs [][]byte) [][]byte {
+	sort.Slice(traceIDs, func(k, h int) bool {
+		return bytes.Compare(traceIDs[k], traceIDs[h]) < 0
 	})
 
 	var prevTraceID []byte
-	traceIDs := make([]model.TraceID, 0)
-	for i := 0; i < len(indexResults); i++ {
-		traceID := indexResults[i]
+	results := make([][]byte, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
 		if !bytes.Equal(prevTraceID, traceID) {
-			traceIDs = append(traceIDs, bytesToTraceID(traceID))
+			results = append(results, traceID)
 			prevTraceID = traceID
 		}
 	}
+	return results
+}
 
-	return traceIDs, err
+// mergeJoinIDs intersects two sorted lists of traceIDs
+func mergeJoinIDs(left, right [][]byte) [][]byte {
+	allocateSize := len(left)
+	if len(right) < allocateSize {
+		allocateSize = len(right)
+	}
+	merged := make([][]byte, 0, allocateSize)
+
+	for l, r := 0, 0; l < len(left) && r < len(right); {
+		switch bytes.Compare(left[l], right[r]) {
+		case 0:
+			merged = append(merged, left[l])
+			l++
+			r++
+		case 1:
+			r++
+		case -1:
+			l++
+		}
+	}
+	return merged
 }
 
 func createPrimaryKeySeekPrefix(traceID model.TraceID) []byte {
@@ -277,28 +772,93 @@ func decodeValue(val []byte, encodeType byte) (*model.Span, error) {
 	return &sp, nil
 }
 
+// GetTraces returns the traces of traceIDs found in the store, in the same order. The traces are fetched by
+// options.Concurrency workers in batches of options.BatchSize, each batch in its own transaction, and kept decoded
+// in an LRU cache once settled. The returned traces may be shared and must not be modified.
 func (tr *TraceReader) GetTraces(traceIDs []model.TraceID) ([]*model.Trace, error) {
-	traces := make([]*model.Trace, 0, len(traceIDs))
-	prefixes := make([][]byte, 0, len(traceIDs))
+	results := make([]*model.Trace, len(traceIDs))
+	missing := make([]int, 0, len(traceIDs))
+	for i, traceID := range traceIDs {
+		if tr.cache != nil {
+			if trace, ok := tr.cache.get(traceID); ok {
+				results[i] = trace
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
 
-	for _, traceID := range traceIDs {
-		prefixes = append(prefixes, createPrimaryKeySeekPrefix(traceID))
+	batches := make(chan []int)
+	errs := make(chan error, tr.options.Concurrency)
+	done := make(chan struct{})
+	var stop sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < tr.options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := tr.fetchTraces(traceIDs, batch, results); err != nil {
+					errs <- err
+					// stop handing out batches
+					stop.Do(func() { close(done) })
+					return
+				}
+			}
+		}()
 	}
 
-	err := tr.store.View(func(txn *badger.Txn) error {
+dispatch:
+	for start := 0; start < len(missing); start += tr.options.BatchSize {
+		end := start + tr.options.BatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		select {
+		case batches <- missing[start:end]:
+		case <-done:
+			break dispatch
+		}
+	}
+	close(batches)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, trace := range results {
+		if trace != nil {
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
+
+// fetchTraces reads the traces of traceIDs at indexes in one transaction into results
+func (tr *TraceReader) fetchTraces(traceIDs []model.TraceID, indexes []int, results []*model.Trace) error {
+	// spans written after a snapshot are missing from it
+	settled := tr.readTime().Add(-defaultTraceSettle)
+	return tr.view(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
+		// a trace is a few keys, prefetching the values of the next ones after each seek is wasted
+		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		var val []byte
-		for _, prefix := range prefixes {
+		for _, i := range indexes {
+			prefix := createPrimaryKeySeekPrefix(traceIDs[i])
 			spans := make([]*model.Span, 0, 32)
 
+			var latest time.Time
 			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 				item := it.Item()
-				val, err := item.ValueCopy(val)
+				var err error
+				val, err = item.ValueCopy(val)
 				if err != nil {
-					return nil
+					return fmt.Errorf("failed to read span of trace %s: %w", traceIDs[i], err)
 				}
 
 				sp, err := decodeValue(val, item.UserMeta()&encodingTypeBits)
@@ -306,21 +866,34 @@ func (tr *TraceReader) GetTraces(traceIDs []model.TraceID) ([]*model.Trace, erro
 					return err
 				}
 				spans = append(spans, sp)
+				if end := sp.StartTime.Add(sp.Duration); end.After(latest) {
+					latest = end
+				}
 			}
 			if len(spans) > 0 {
 				trace := &model.Trace{
 					Spans: spans,
 				}
-				traces = append(traces, trace)
+				results[i] = trace
+				// spans may still be written to a recent trace
+				if tr.cache != nil && latest.Before(settled) {
+					tr.cache.add(traceIDs[i], trace)
+				}
 			}
 		}
 		return nil
 	})
-	return traces, err
 }
 
-func (tr *TraceReader) GetPercentileLatency(percentiles []float64, traces []*model.Trace, filter func(span *model.Span) bool) []time.Duration {
-	latencies := make([]time.Duration, 0)
+// GetPercentileLatency returns the percentiles of the latencies of traces, summed over the spans passing filter.
+// The percentiles are 0 if no trace has any
+func GetPercentileLatency(percentiles []float64, traces []*model.Trace, filter func(span *model.Span) bool, options AnalysisOptions) []time.Duration {
+	return GetLatencySketch(traces, filter, options).Quantiles(percentiles)
+}
+
+// GetLatencySketch sketches the latencies of traces, summed over the spans passing filter and weighted for sampling
+func GetLatencySketch(traces []*model.Trace, filter func(span *model.Span) bool, options AnalysisOptions) *Sketch {
+	sketch := NewSketch()
 	for _, trace := range traces {
 		var latency int64
 		for _, span := range trace.Spans {
@@ -329,58 +902,40 @@ func (tr *TraceReader) GetPercentileLatency(percentiles []float64, traces []*mod
 			}
 		}
 		if latency != 0 {
-			latencies = append(latencies, time.Duration(latency))
+			sketch.AddWeighted(time.Duration(latency), options.TraceWeight(trace))
 		}
 	}
-	sort.Slice(latencies, func(i, j int) bool {
-		return latencies[i] < latencies[j]
-	})
+	return sketch
+}
 
-	results := make([]time.Duration, len(percentiles))
-	for _, percentile := range percentiles {
-		index := int(float64(len(latencies)) * percentile)
-		results = append(results, latencies[index])
+// GetPercentileLatencyByOperation returns the percentiles of the root span latencies of each of opNames,
+// 0 for the operations without any
+func GetPercentileLatencyByOperation(percentiles []float64, traces []*model.Trace, opNames []string, options AnalysisOptions) map[string][]time.Duration {
+	results := make(map[string][]time.Duration, len(opNames))
+	for op, sketch := range GetLatencySketchByOperation(traces, opNames, options) {
+		results[op] = sketch.Quantiles(percentiles)
 	}
-
 	return results
 }
 
-func (tr *TraceReader) GetPercentileLatencyByOperation(percentiles []float64, traces []*model.Trace, opNames []string) map[string][]time.Duration {
-	allLatencies := make(map[string][]time.Duration, 0)
+// GetLatencySketchByOperation sketches the root span latencies of each of opNames, weighted for sampling
+func GetLatencySketchByOperation(traces []*model.Trace, opNames []string, options AnalysisOptions) map[string]*Sketch {
+	sketches := make(map[string]*Sketch, len(opNames))
 	for _, opName := range opNames {
-		allLatencies[opName] = make([]time.Duration, 0)
+		sketches[opName] = NewSketch()
 	}
 
 	for _, trace := range traces {
-		var latency time.Duration
-		var opName string
-		for _, span := range trace.Spans {
-			if span.SpanID.String() == span.TraceID.String() {
-				opName = span.OperationName
-				latency = span.Duration
-				break
-			}
+		root := rootSpan(trace)
+		if root == nil {
+			continue
 		}
-		if _, ok := allLatencies[opName]; ok {
-			if latency != 0 {
-				allLatencies[opName] = append(allLatencies[opName], latency)
+		if sketch, ok := sketches[root.OperationName]; ok {
+			if root.Duration != 0 {
+				sketch.AddWeighted(root.Duration, options.TraceWeight(trace))
 			}
 		}
 	}
 
-	for _, latencies := range allLatencies {
-		sort.Slice(latencies, func(i, j int) bool {
-			return latencies[i] < latencies[j]
-		})
-	}
-
-	results := make(map[string][]time.Duration, len(percentiles))
-	for op, latencies := range allLatencies {
-		for _, percentile := range percentiles {
-			index := int(float64(len(latencies)) * percentile)
-			results[op] = append(results[op], latencies[index])
-		}
-	}
-
-	return results
+	return sketches
 }
@@ -0,0 +1,420 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// scanPrimaryKeys finds the traces of query by scanning every span key, as QueryTimeRange did before it
+// used the indexes. A trace matches when, among its spans inside the time range, each filter of the query is met by
+// one of them: the service and operation, every tag and the duration.
+func scanPrimaryKeys(tr *TraceReader, query *Query) (map[model.TraceID]struct{}, error) {
+	minTimeStamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(minTimeStamp, timeAsEpochMicroseconds(query.startTime))
+
+	maxTimeStamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(maxTimeStamp, timeAsEpochMicroseconds(query.endTime))
+
+	// the filters each trace has met so far
+	met := make(map[model.TraceID]map[string]struct{})
+	err := tr.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte{spanKeyPrefix}
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			timestamp := key[sizeOfTraceID+1 : sizeOfTraceID+1+8]
+			if bytes.Compare(timestamp, minTimeStamp) < 0 || bytes.Compare(timestamp, maxTimeStamp) > 0 {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			span, err := decodeValue(val, item.UserMeta()&encodingTypeBits)
+			if err != nil {
+				return err
+			}
+
+			traceID := bytesToTraceID(key[1 : sizeOfTraceID+1])
+			if _, ok := met[traceID]; !ok {
+				met[traceID] = make(map[string]struct{})
+			}
+			for _, filter := range spanFilters(span, query) {
+				met[traceID][filter] = struct{}{}
+			}
+		}
+		return nil
+	})
+
+	filters := 1 + len(query.tags)
+	if query.durationMin != 0 || query.durationMax != 0 {
+		filters++
+	}
+	traceIDs := make(map[model.TraceID]struct{})
+	for traceID, m := range met {
+		if len(m) == filters {
+			traceIDs[traceID] = struct{}{}
+		}
+	}
+	return traceIDs, err
+}
+
+// spanFilters returns the filters of query span meets, the tags like the writer indexes them
+func spanFilters(span *model.Span, query *Query) []string {
+	var filters []string
+	if query.serviceName == "" || (span.Process.ServiceName == query.serviceName &&
+		(query.operationName == "" || span.OperationName == query.operationName)) {
+		filters = append(filters, "service")
+	}
+
+	if span.Process.ServiceName == query.serviceName {
+		kvs := append(append([]model.KeyValue{}, span.Tags...), span.Process.Tags...)
+		for _, log := range span.Logs {
+			kvs = append(kvs, log.Fields...)
+		}
+		for k, v := range query.tags {
+			for _, kv := range kvs {
+				if kv.Key == k && kv.AsString() == v {
+					filters = append(filters, "tag "+k)
+					break
+				}
+			}
+		}
+	}
+
+	if query.durationMin != 0 || query.durationMax != 0 {
+		duration := model.DurationAsMicroseconds(span.Duration)
+		if duration >= model.DurationAsMicroseconds(query.durationMin) &&
+			(query.durationMax == 0 || duration <= model.DurationAsMicroseconds(query.durationMax)) {
+			filters = append(filters, "duration")
+		}
+	}
+	return filters
+}
+
+// writeStore writes traces to a new store in a temp directory and returns its path
+func writeStore(tb testing.TB, traces ...[]*model.Trace) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	appendStore(tb, dir, traces...)
+	return dir
+}
+
+func appendStore(tb testing.TB, dir string, traces ...[]*model.Trace) {
+	tb.Helper()
+	writer, err := NewSpanWriter(dir, WriterOptions{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer writer.Close()
+	for _, t := range traces {
+		if err := writer.WriteTraces(t); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func openReader(tb testing.TB, dir string) *TraceReader {
+	tb.Helper()
+	reader, err := NewTraceReader(dir, ReaderOptions{ReadOnly: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(reader.Close)
+	return reader
+}
+
+func TestOpenLockedStore(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	dir := writeStore(t, GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 10, Start: start, Duration: time.Second, Seed: 1}))
+	// a writer, like a running jaeger, holds the store
+	writer, err := NewTraceReader(dir, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	for _, readOnly := range []bool{false, true} {
+		if _, err := NewTraceReader(dir, ReaderOptions{ReadOnly: readOnly}); !errors.Is(err, ErrStoreLocked) {
+			t.Errorf("opening a locked store read-only %v: %v, want %v", readOnly, err, ErrStoreLocked)
+		}
+	}
+
+	// a snapshot is read instead
+	reader, err := NewTraceReader(dir, ReaderOptions{SnapshotDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceIDs, err := reader.QueryTimeRange(NewQuery("", start, start.Add(time.Second), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traceIDs) != 10 {
+		t.Errorf("read %d traces from the snapshot, want 10", len(traceIDs))
+	}
+	reader.Close()
+	reader.Close()
+}
+
+func TestQueryWithoutServiceNameMatchesPrimaryKeyScan(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	dir := writeStore(t,
+		GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 300, Start: start, Duration: 3 * time.Second, Seed: 1}),
+		GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 300, Start: start.Add(-time.Hour), Duration: time.Minute, Seed: 2}),
+		// services sharing a name prefix with the fixture ones
+		[]*model.Trace{prefixedServiceTrace(start.Add(time.Second), "user-service-v2"), prefixedServiceTrace(start.Add(time.Second), "user")},
+	)
+	reader := openReader(t, dir)
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"everything", start.Add(-2 * time.Hour), start.Add(time.Hour)},
+		{"recent", start, start.Add(3 * time.Second)},
+		{"middle", start.Add(time.Second), start.Add(2 * time.Second)},
+		{"old", start.Add(-time.Hour), start.Add(-59 * time.Minute)},
+		{"nothing", start.Add(-30 * time.Minute), start.Add(-20 * time.Minute)},
+		{"single microsecond", start.Add(time.Second), start.Add(time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := NewQuery("", tt.start, tt.end, 0)
+			want, err := scanPrimaryKeys(reader, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.QueryTimeRange(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Errorf("found %d traces, the primary key scan %d", len(got), len(want))
+			}
+			for _, traceID := range got {
+				if _, ok := want[traceID]; !ok {
+					t.Errorf("trace %s is not found by the primary key scan", traceID)
+				}
+			}
+		})
+	}
+}
+
+func prefixedServiceTrace(start time.Time, service string) *model.Trace {
+	traceID := model.NewTraceID(0, uint64(len(service)))
+	return &model.Trace{Spans: []*model.Span{{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(1),
+		OperationName: "op",
+		StartTime:     start,
+		Duration:      time.Millisecond,
+		Process:       model.NewProcess(service, nil),
+	}}}
+}
+
+// BenchmarkQueryWithoutServiceName queries the latest window while the store grows with old spans,
+// the query time should stay flat. The largest store takes minutes to write and is left out with -short
+func BenchmarkQueryWithoutServiceName(b *testing.B) {
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	window := 5 * time.Second
+	dir := writeStore(b, GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 200, Start: now.Add(-window), Duration: window, Seed: 1}))
+
+	const step = 20000
+	sizes := []int{0, step, 2 * step, 4 * step}
+	if !testing.Short() {
+		// fixture traces have about 4 spans, so well over a million spans
+		sizes = append(sizes, 16*step)
+	}
+	written := 0
+	for i, size := range sizes {
+		// old traces are at least one window older than the queried range
+		if size > written {
+			appendStore(b, dir, GenerateSocialNetworkTraces(FixtureOptions{
+				NumTraces: size - written,
+				Start:     now.Add(-24 * time.Hour),
+				Duration:  24*time.Hour - 2*window,
+				Seed:      int64(i + 1),
+			}))
+			written = size
+		}
+
+		b.Run(fmt.Sprintf("old traces %d", size), func(b *testing.B) {
+			reader := openReader(b, dir)
+			query := NewQuery("", now.Add(-window), now, 0)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if _, err := reader.QueryTimeRange(query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetOperationsOfServicesSharingAPrefix(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	// user + "-service/read" and user-service + "/read" share their operation index keys
+	spans := []struct{ service, operation string }{
+		{"user", "-service/read"},
+		{"user", "/login"},
+		{"user-service", "/read"},
+		{"user-service", "/write"},
+		{"user-service", "/write"},
+		{"user-service-v2", "/read"},
+	}
+	var traces []*model.Trace
+	for i, s := range spans {
+		traces = append(traces, &model.Trace{Spans: []*model.Span{{
+			TraceID:       model.NewTraceID(1, uint64(i+1)),
+			SpanID:        model.NewSpanID(1),
+			OperationName: s.operation,
+			StartTime:     start.Add(time.Duration(i) * time.Millisecond),
+			Duration:      time.Millisecond,
+			Process:       model.NewProcess(s.service, nil),
+		}}})
+	}
+	reader := openReader(t, writeStore(t, traces))
+
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{"user", []string{"-service/read", "/login"}},
+		{"user-service", []string{"/read", "/write"}},
+		{"user-service-v2", []string{"/read"}},
+		{"nobody", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := reader.GetOperations(tt.service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("operations of %s are %v, want %v", tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTimeRangeFilters(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	failingPod := FixturePodName("post-storage-service", 0)
+	traces := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 300, Start: start, Duration: 3 * time.Second,
+		PodsPerService: 2, FailingPod: failingPod, FailureRate: 0.3, Seed: 1})
+	reader := openReader(t, writeStore(t, traces))
+
+	// the ip of the other replica, never failing
+	var otherIP string
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			if hostname, _ := model.KeyValues(span.Process.Tags).FindByKey("hostname"); hostname.AsString() == FixturePodName("post-storage-service", 1) {
+				ip, _ := model.KeyValues(span.Process.Tags).FindByKey("ip")
+				otherIP = ip.AsString()
+			}
+		}
+	}
+
+	end := start.Add(3 * time.Second)
+	tests := []struct {
+		name      string
+		query     *Query
+		wantEmpty bool
+	}{
+		{
+			name:  "tag",
+			query: NewQuery("post-storage-service", start, end, 0).WithTags(map[string]string{"error": "true"}),
+		},
+		{
+			name:  "tags",
+			query: NewQuery("post-storage-service", start, end, 0).WithTags(map[string]string{"error": "true", "hostname": failingPod}),
+		},
+		{
+			name: "tag and operation",
+			query: NewQuery(fixtureEntryService, start, end, 0).WithOperationName("/wrk2-api/home-timeline/read").
+				WithTags(map[string]string{"error": "true"}),
+		},
+		{
+			name:  "process tag in part of the time range",
+			query: NewQuery("post-storage-service", start.Add(time.Second), start.Add(2*time.Second), 0).WithTags(map[string]string{"ip": otherIP}),
+		},
+		{
+			name:  "duration",
+			query: NewQuery(fixtureEntryService, start, end, 0).WithDuration(3*time.Millisecond, 5*time.Millisecond),
+		},
+		{
+			name:  "min duration",
+			query: NewQuery(fixtureEntryService, start, end, 0).WithDuration(5*time.Millisecond, 0),
+		},
+		{
+			name:  "max duration",
+			query: NewQuery("user-timeline-service", start, end, 0).WithDuration(0, 500*time.Microsecond),
+		},
+		{
+			name:  "duration without service",
+			query: NewQuery("", start, end, 0).WithDuration(5*time.Millisecond, 0),
+		},
+		{
+			name: "tag and duration",
+			query: NewQuery("post-storage-service", start, end, 0).WithDuration(5*time.Millisecond, 0).
+				WithTags(map[string]string{"hostname": failingPod}),
+		},
+		{
+			name:      "empty intersection",
+			query:     NewQuery("post-storage-service", start, end, 0).WithTags(map[string]string{"hostname": failingPod, "ip": otherIP}),
+			wantEmpty: true,
+		},
+		{
+			name:      "no duration",
+			query:     NewQuery(fixtureEntryService, start, end, 0).WithDuration(time.Hour, 0),
+			wantEmpty: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := scanPrimaryKeys(reader, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.QueryTimeRange(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantEmpty != (len(want) == 0) {
+				t.Fatalf("the primary key scan found %d traces", len(want))
+			}
+			if len(got) != len(want) {
+				t.Errorf("found %d traces, the primary key scan %d", len(got), len(want))
+			}
+			for _, traceID := range got {
+				if _, ok := want[traceID]; !ok {
+					t.Errorf("trace %s is not found by the primary key scan", traceID)
+				}
+			}
+
+			// the limit keeps the latest of them
+			query := *tt.query
+			query.numTraces = 5
+			limited, err := reader.QueryTimeRange(&query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) > 5 && (len(limited) != 5 || limited[0] != got[0]) {
+				t.Errorf("limited to %v, want the first 5 of %v", limited, got[:5])
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	jsonmodel "github.com/jaegertracing/jaeger/model/json"
+)
+
+// FileReader serves traces loaded from a file, e.g. downloaded from the Jaeger UI or captured from Zipkin,
+// so they can be analysed without a live store
+type FileReader struct {
+	traces map[model.TraceID]*model.Trace
+}
+
+// NewFileReader loads a Jaeger JSON ({"data": [traces]}) or Zipkin v2 JSON ([spans]) file
+func NewFileReader(p string) (*FileReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(buf)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return NewZipkinJSONReader(bytes.NewReader(buf))
+	}
+	return NewJaegerJSONReader(bytes.NewReader(buf))
+}
+
+func newFileReader(spans []*model.Span) *FileReader {
+	fr := &FileReader{
+		traces: make(map[model.TraceID]*model.Trace),
+	}
+	for _, span := range spans {
+		trace, ok := fr.traces[span.TraceID]
+		if !ok {
+			trace = &model.Trace{}
+			fr.traces[span.TraceID] = trace
+		}
+		trace.Spans = append(trace.Spans, span)
+	}
+	return fr
+}
+
+// QueryTimeRange matches traces the same way TraceWindow does
+func (fr *FileReader) QueryTimeRange(query *Query) ([]model.TraceID, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	traceIDs := make([]model.TraceID, 0)
+	startTimes := make(map[model.TraceID]time.Time)
+	for traceID, trace := range fr.traces {
+		if matchTrace(trace, query) {
+			traceIDs = append(traceIDs, traceID)
+			startTimes[traceID] = earliestStartTime(trace)
+		}
+	}
+	sort.Slice(traceIDs, func(i, j int) bool {
+		// This sorts by timestamp to descending order
+		return startTimes[traceIDs[i]].After(startTimes[traceIDs[j]])
+	})
+
+	if query.numTraces > 0 && query.numTraces < len(traceIDs) {
+		traceIDs = traceIDs[:query.numTraces]
+	}
+	return traceIDs, nil
+}
+
+func (fr *FileReader) GetTraces(traceIDs []model.TraceID) ([]*model.Trace, error) {
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		if trace, ok := fr.traces[traceID]; ok {
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
+
+// AllTraces returns every trace of the file, from the latest to the oldest
+func (fr *FileReader) AllTraces() []*model.Trace {
+	traces := make([]*model.Trace, 0, len(fr.traces))
+	for _, trace := range fr.traces {
+		traces = append(traces, trace)
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		return earliestStartTime(traces[i]).After(earliestStartTime(traces[j]))
+	})
+	return traces
+}
+
+func (fr *FileReader) GetServices() ([]string, error) {
+	return listServices(fr.AllTraces()), nil
+}
+
+func (fr *FileReader) GetOperations(service string) ([]string, error) {
+	return listOperations(fr.AllTraces())[service], nil
+}
+
+func (fr *FileReader) Close() {}
+
+// NewJaegerJSONReader reads the format of the Jaeger UI download and of /api/traces
+func NewJaegerJSONReader(r io.Reader) (*FileReader, error) {
+	var doc struct {
+		Data []jsonmodel.Trace `json:"data"`
+	}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode jaeger json: %w", err)
+	}
+
+	spans := make([]*model.Span, 0)
+	for _, trace := range doc.Data {
+		for i := range trace.Spans {
+			span, err := jaegerJSONToSpan(&trace.Spans[i], trace.Processes)
+			if err != nil {
+				return nil, err
+			}
+			spans = append(spans, span)
+		}
+	}
+	return newFileReader(spans), nil
+}
+
+func jaegerJSONToSpan(s *jsonmodel.Span, processes map[jsonmodel.ProcessID]jsonmodel.Process) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(string(s.TraceID))
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := model.SpanIDFromString(string(s.SpanID))
+	if err != nil {
+		return nil, err
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.OperationName,
+		Flags:         model.Flags(s.Flags),
+		StartTime:     model.EpochMicrosecondsAsTime(s.StartTime),
+		Duration:      model.MicrosecondsAsDuration(s.Duration),
+		Warnings:      s.Warnings,
+	}
+
+	for _, ref := range s.References {
+		refTraceID, err := model.TraceIDFromString(string(ref.TraceID))
+		if err != nil {
+			return nil, err
+		}
+		refSpanID, err := model.SpanIDFromString(string(ref.SpanID))
+		if err != nil {
+			return nil, err
+		}
+		refType := model.ChildOf
+		if ref.RefType == jsonmodel.FollowsFrom {
+			refType = model.FollowsFrom
+		}
+		span.References = append(span.References, model.SpanRef{TraceID: refTraceID, SpanID: refSpanID, RefType: refType})
+	}
+	// deprecated, only written by old versions
+	if s.ParentSpanID != "" && len(span.References) == 0 {
+		parentID, err := model.SpanIDFromString(string(s.ParentSpanID))
+		if err != nil {
+			return nil, err
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parentID))
+	}
+
+	if span.Tags, err = jaegerJSONToKeyValues(s.Tags); err != nil {
+		return nil, err
+	}
+	for _, l := range s.Logs {
+		fields, err := jaegerJSONToKeyValues(l.Fields)
+		if err != nil {
+			return nil, err
+		}
+		span.Logs = append(span.Logs, model.Log{Timestamp: model.EpochMicrosecondsAsTime(l.Timestamp), Fields: fields})
+	}
+
+	process := s.Process
+	if process == nil {
+		p, ok := processes[s.ProcessID]
+		if !ok {
+			return nil, fmt.Errorf("process %s of span %s not found", s.ProcessID, s.SpanID)
+		}
+		process = &p
+	}
+	tags, err := jaegerJSONToKeyValues(process.Tags)
+	if err != nil {
+		return nil, err
+	}
+	// keep the order of the tags, model.NewProcess would sort them
+	span.Process = &model.Process{ServiceName: process.ServiceName, Tags: tags}
+	return span, nil
+}
+
+func jaegerJSONToKeyValues(kvs []jsonmodel.KeyValue) ([]model.KeyValue, error) {
+	results := make([]model.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		result, err := jaegerJSONToKeyValue(kv)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func jaegerJSONToKeyValue(kv jsonmodel.KeyValue) (model.KeyValue, error) {
+	str := fmt.Sprint(kv.Value)
+	switch kv.Type {
+	case jsonmodel.BoolType:
+		if b, ok := kv.Value.(bool); ok {
+			return model.Bool(kv.Key, b), nil
+		}
+		b, err := strconv.ParseBool(str)
+		return model.Bool(kv.Key, b), err
+	case jsonmodel.Int64Type:
+		i, err := strconv.ParseInt(str, 10, 64)
+		return model.Int64(kv.Key, i), err
+	case jsonmodel.Float64Type:
+		f, err := strconv.ParseFloat(str, 64)
+		return model.Float64(kv.Key, f), err
+	case jsonmodel.BinaryType:
+		b, err := base64.StdEncoding.DecodeString(str)
+		return model.Binary(kv.Key, b), err
+	default:
+		return model.String(kv.Key, str), nil
+	}
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int    `json:"port"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      uint64             `json:"timestamp"`
+	Duration       uint64             `json:"duration"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint"`
+	Annotations    []zipkinAnnotation `json:"annotations"`
+	Tags           map[string]string  `json:"tags"`
+	Shared         bool               `json:"shared"`
+}
+
+// NewZipkinJSONReader reads a Zipkin v2 JSON list of spans
+func NewZipkinJSONReader(r io.Reader) (*FileReader, error) {
+	var zipkinSpans []zipkinSpan
+	if err := json.NewDecoder(r).Decode(&zipkinSpans); err != nil {
+		return nil, fmt.Errorf("failed to decode zipkin json: %w", err)
+	}
+
+	spans := make([]*model.Span, 0, len(zipkinSpans))
+	shared := make(map[*model.Span]struct{})
+	for i := range zipkinSpans {
+		span, err := zipkinToSpan(&zipkinSpans[i])
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, span)
+		if zipkinSpans[i].Shared {
+			shared[span] = struct{}{}
+		}
+	}
+	fr := newFileReader(spans)
+	for _, trace := range fr.traces {
+		dedupeSharedSpanIDs(trace, shared)
+	}
+	return fr, nil
+}
+
+// dedupeSharedSpanIDs gives the server half of an RPC, which shares the span ID of its client half in Zipkin,
+// an ID of its own and makes it ChildOf the client half, like the span ID deduper of jaeger-query does.
+// The spans below the server half follow it to its new ID. Otherwise NewGraph would drop the server half as a
+// duplicate, and the callee with it.
+func dedupeSharedSpanIDs(trace *model.Trace, shared map[*model.Span]struct{}) {
+	ids := make(map[model.SpanID]int, len(trace.Spans))
+	maxID := model.SpanID(0)
+	for _, span := range trace.Spans {
+		ids[span.SpanID]++
+		if span.SpanID > maxID {
+			maxID = span.SpanID
+		}
+	}
+
+	newIDs := make(map[model.SpanID]model.SpanID)
+	servers := make(map[*model.Span]struct{})
+	for _, span := range trace.Spans {
+		if _, ok := shared[span]; !ok || ids[span.SpanID] < 2 {
+			continue
+		}
+		clientID := span.SpanID
+		newID := maxID + 1
+		for ids[newID] > 0 {
+			newID++
+		}
+		maxID = newID
+		ids[clientID]--
+		ids[newID]++
+		newIDs[clientID] = newID
+		servers[span] = struct{}{}
+		span.SpanID = newID
+		span.References = []model.SpanRef{model.NewChildOfRef(span.TraceID, clientID)}
+	}
+
+	for _, span := range trace.Spans {
+		if _, ok := servers[span]; ok {
+			continue
+		}
+		for i, ref := range span.References {
+			if newID, ok := newIDs[ref.SpanID]; ok && ref.TraceID == span.TraceID {
+				span.References[i].SpanID = newID
+			}
+		}
+	}
+}
+
+func zipkinToSpan(s *zipkinSpan) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(s.TraceID)
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := model.SpanIDFromString(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.Name,
+		StartTime:     model.EpochMicrosecondsAsTime(s.Timestamp),
+		Duration:      model.MicrosecondsAsDuration(s.Duration),
+	}
+	if s.ParentID != "" {
+		parentID, err := model.SpanIDFromString(s.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parentID))
+	}
+
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// zipkin puts the error message in the error tag, jaeger only flags it
+		if k == "error" {
+			span.Tags = append(span.Tags, model.Bool("error", true))
+			continue
+		}
+		span.Tags = append(span.Tags, model.String(k, s.Tags[k]))
+	}
+	if s.Kind != "" {
+		span.Tags = append(span.Tags, model.String("span.kind", strings.ToLower(s.Kind)))
+	}
+	for _, annotation := range s.Annotations {
+		span.Logs = append(span.Logs, model.Log{
+			Timestamp: model.EpochMicrosecondsAsTime(annotation.Timestamp),
+			Fields:    []model.KeyValue{model.String("event", annotation.Value)},
+		})
+	}
+
+	// laid out like the process tags of jaeger clients: version, hostname, ip
+	process := &model.Process{ServiceName: unknownServiceName}
+	if endpoint := s.LocalEndpoint; endpoint != nil {
+		if endpoint.ServiceName != "" {
+			process.ServiceName = endpoint.ServiceName
+		}
+		ip := endpoint.IPv4
+		if ip == "" {
+			ip = endpoint.IPv6
+		}
+		process.Tags = []model.KeyValue{
+			model.String("jaeger.version", "zipkin"),
+			model.String("hostname", ip),
+			model.String("ip", ip),
+		}
+	}
+	span.Process = process
+	return span, nil
+}
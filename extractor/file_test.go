@@ -0,0 +1,149 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestJaegerJSONReader(t *testing.T) {
+	fr, err := NewFileReader("testdata/jaeger.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traces := fr.AllTraces()
+	if len(traces) != 2 {
+		t.Fatalf("read %d traces, want 2", len(traces))
+	}
+	// the latest first
+	if traces[0].Spans[0].TraceID != model.NewTraceID(1, 2) {
+		t.Errorf("first trace is %s, want the latest", traces[0].Spans[0].TraceID)
+	}
+
+	trace := traces[1]
+	if len(trace.Spans) != 3 {
+		t.Fatalf("trace has %d spans, want 3", len(trace.Spans))
+	}
+	spans := make(map[model.SpanID]*model.Span)
+	for _, span := range trace.Spans {
+		spans[span.SpanID] = span
+	}
+
+	root := spans[1]
+	if root.OperationName != "GET /home" || root.Process.ServiceName != "frontend" ||
+		!root.StartTime.Equal(time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)) || root.Duration != 10*time.Millisecond {
+		t.Errorf("root span is %v", root)
+	}
+	if status, ok := model.KeyValues(root.Tags).FindByKey("http.status_code"); !ok || status.VType != model.Int64Type || status.Int64() != 200 {
+		t.Errorf("status code tag is %v", status)
+	}
+	if len(root.Logs) != 1 || root.Logs[0].Fields[0].AsString() != "parsed" {
+		t.Errorf("logs are %v", root.Logs)
+	}
+
+	child := spans[2]
+	if len(child.References) != 1 || child.References[0].SpanID != 1 || child.References[0].RefType != model.ChildOf {
+		t.Errorf("references of the child are %v", child.References)
+	}
+	if !IsSpanFailed(child) {
+		t.Error("child is not failed")
+	}
+	if ratio, ok := model.KeyValues(child.Tags).FindByKey("ratio"); !ok || ratio.Float64() != 0.5 {
+		t.Errorf("ratio tag is %v", ratio)
+	}
+
+	// parentSpanID and an inline process, as old versions wrote them
+	cache := spans[3]
+	if len(cache.References) != 1 || cache.References[0].SpanID != 2 {
+		t.Errorf("references of the deprecated parent are %v", cache.References)
+	}
+	if cache.Process.ServiceName != "memcached" {
+		t.Errorf("inline process is %v", cache.Process)
+	}
+
+	graph := NewGraph(trace, AnalysisOptions{})
+	if !graph.GetCompleteness().Complete() {
+		t.Errorf("trace is not a single tree: %s", graph.GetCompleteness())
+	}
+	selfTimes := graph.GetSelfTimeByPod()
+	for _, pod := range []string{"frontend-0", "post-storage-service-0", "memcached-0"} {
+		if _, ok := selfTimes[pod]; !ok {
+			t.Errorf("no self time of %s", pod)
+		}
+	}
+
+	services, _ := fr.GetServices()
+	if len(services) != 3 {
+		t.Errorf("services are %v", services)
+	}
+	traceIDs, err := fr.QueryTimeRange(NewQuery("post-storage-service", root.StartTime, root.StartTime.Add(time.Hour), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traceIDs) != 1 || traceIDs[0] != root.TraceID {
+		t.Errorf("traces of post-storage-service are %v", traceIDs)
+	}
+}
+
+func TestZipkinJSONReader(t *testing.T) {
+	fr, err := NewFileReader("testdata/zipkin.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traces := fr.AllTraces()
+	if len(traces) != 1 {
+		t.Fatalf("read %d traces, want 1", len(traces))
+	}
+	trace := traces[0]
+	if len(trace.Spans) != 4 {
+		t.Fatalf("trace has %d spans, want 4", len(trace.Spans))
+	}
+
+	var client, server, find *model.Span
+	for _, span := range trace.Spans {
+		switch {
+		case span.OperationName == "read_posts" && span.Process.ServiceName == "frontend":
+			client = span
+		case span.OperationName == "read_posts":
+			server = span
+		case span.OperationName == "find":
+			find = span
+		}
+	}
+	if client == nil || server == nil || find == nil {
+		t.Fatalf("spans are %v", trace.Spans)
+	}
+	// the server half of the shared span gets its own ID, below the client half
+	if client.SpanID != 0xb || server.SpanID == client.SpanID {
+		t.Errorf("client span is %s and server span %s", client.SpanID, server.SpanID)
+	}
+	if len(server.References) != 1 || server.References[0].SpanID != client.SpanID {
+		t.Errorf("references of the server span are %v", server.References)
+	}
+	if len(find.References) != 1 || find.References[0].SpanID != server.SpanID {
+		t.Errorf("references of the call of the server are %v", find.References)
+	}
+	if kind, _ := model.KeyValues(server.Tags).FindByKey("span.kind"); kind.AsString() != "server" {
+		t.Errorf("kind of the server span is %v", kind)
+	}
+	if !IsSpanFailed(server) {
+		t.Error("server span is not failed")
+	}
+	if len(server.Logs) != 1 || server.Logs[0].Fields[0].AsString() != "cache miss" {
+		t.Errorf("logs are %v", server.Logs)
+	}
+
+	graph := NewGraph(trace, AnalysisOptions{})
+	completeness := graph.GetCompleteness()
+	if !completeness.Complete() || completeness.DuplicateSpans != 0 || completeness.NumConnected != 4 {
+		t.Errorf("trace is not a single tree: %s", completeness)
+	}
+	selfTimes := graph.GetSelfTimeByPod()
+	if _, ok := selfTimes["10.244.0.3"]; !ok {
+		t.Errorf("the callee is missing from the pods: %v", selfTimes)
+	}
+	if _, ok := graph.GetCriticalPath().GetContributionByPod()["10.244.0.3"]; !ok {
+		t.Error("the callee is missing from the critical path")
+	}
+}
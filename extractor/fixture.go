@@ -0,0 +1,203 @@
+package extractor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	fixtureEntryService = "nginx-web-server"
+	// network latency of a call, each way
+	fixtureMinGap = 50 * time.Microsecond
+	fixtureMaxGap = 150 * time.Microsecond
+	// spread of the self times, as the sigma of a lognormal
+	fixtureJitter = 0.25
+)
+
+// fixtureCall is a span of the fixture call graph, and the calls it makes
+type fixtureCall struct {
+	service   string
+	operation string
+	// median self time, half of it before the calls and half after
+	self time.Duration
+	// stages run one after another, the calls of a stage concurrently
+	stages [][]*fixtureCall
+	// async calls are FollowsFrom the span, it does not wait for them
+	async []*fixtureCall
+}
+
+// the call graph of the social network benchmark, weighted like wrk2's mixed-workload.lua
+var socialNetworkWorkload = []struct {
+	weight int
+	call   *fixtureCall
+}{
+	{1, &fixtureCall{fixtureEntryService, "/wrk2-api/post/compose", 1 * time.Millisecond, [][]*fixtureCall{{
+		{"compose-post-service", "compose_post_server", 500 * time.Microsecond, [][]*fixtureCall{{
+			{"text-service", "compose_text_server", 300 * time.Microsecond, [][]*fixtureCall{{
+				{"url-shorten-service", "compose_urls_server", 400 * time.Microsecond, nil, nil},
+				{"user-mention-service", "compose_user_mentions_server", 400 * time.Microsecond, nil, nil},
+			}}, nil},
+			{"media-service", "compose_media_server", 200 * time.Microsecond, nil, nil},
+			{"unique-id-service", "compose_unique_id_server", 100 * time.Microsecond, nil, nil},
+			{"user-service", "compose_creator_server", 200 * time.Microsecond, nil, nil},
+		}, {
+			{"post-storage-service", "store_post_server", 1 * time.Millisecond, nil, nil},
+			{"user-timeline-service", "write_user_timeline_server", 800 * time.Microsecond, nil, nil},
+		}}, []*fixtureCall{
+			{"write-home-timeline-service", "write_home_timeline_server", 500 * time.Microsecond, [][]*fixtureCall{{
+				{"social-graph-service", "get_followers_server", 600 * time.Microsecond, nil, nil},
+			}}, nil},
+		}},
+	}}, nil}},
+	{6, &fixtureCall{fixtureEntryService, "/wrk2-api/home-timeline/read", 800 * time.Microsecond, [][]*fixtureCall{{
+		{"home-timeline-service", "read_home_timeline_server", 500 * time.Microsecond, [][]*fixtureCall{{
+			{"post-storage-service", "post_storage_read_posts_server", 1200 * time.Microsecond, nil, nil},
+		}}, nil},
+	}}, nil}},
+	{3, &fixtureCall{fixtureEntryService, "/wrk2-api/user-timeline/read", 800 * time.Microsecond, [][]*fixtureCall{{
+		{"user-timeline-service", "read_user_timeline_server", 600 * time.Microsecond, [][]*fixtureCall{{
+			{"post-storage-service", "post_storage_read_posts_server", 1200 * time.Microsecond, nil, nil},
+		}}, nil},
+	}}, nil}},
+}
+
+type FixtureOptions struct {
+	NumTraces int
+	// the traces start evenly spread over [Start, Start+Duration)
+	Start    time.Time
+	Duration time.Duration
+	// PodsPerService is the number of replicas of every service, 1 if 0
+	PodsPerService int
+	// SlowPod multiplies the self time of the pod by SlowFactor, to plant a bottleneck
+	SlowPod    string
+	SlowFactor float64
+	// FailingPod fails FailureRate of its spans, and so their callers
+	FailingPod  string
+	FailureRate float64
+	Seed        int64
+}
+
+// FixturePodName returns the name of a replica of service in the fixtures, reported as its hostname
+func FixturePodName(service string, replica int) string {
+	return fmt.Sprintf("%s-%d", service, replica)
+}
+
+type fixtureGenerator struct {
+	options   FixtureOptions
+	r         *rand.Rand
+	processes map[string]*model.Process
+}
+
+// GenerateSocialNetworkTraces synthesizes traces of the social network benchmark: compose-post,
+// read-home-timeline and read-user-timeline requests entering at nginx-web-server, mixed 1:6:3.
+// Every call is a server span ChildOf the caller, except the home timeline fan-out which FollowsFrom it.
+func GenerateSocialNetworkTraces(options FixtureOptions) []*model.Trace {
+	if options.PodsPerService <= 0 {
+		options.PodsPerService = 1
+	}
+	g := &fixtureGenerator{
+		options:   options,
+		r:         rand.New(rand.NewSource(options.Seed)),
+		processes: make(map[string]*model.Process),
+	}
+
+	totalWeight := 0
+	for _, w := range socialNetworkWorkload {
+		totalWeight += w.weight
+	}
+	traces := make([]*model.Trace, 0, options.NumTraces)
+	for i := 0; i < options.NumTraces; i++ {
+		pick := g.r.Intn(totalWeight)
+		var call *fixtureCall
+		for _, w := range socialNetworkWorkload {
+			if pick < w.weight {
+				call = w.call
+				break
+			}
+			pick -= w.weight
+		}
+
+		start := options.Start.Add(options.Duration * time.Duration(i) / time.Duration(options.NumTraces))
+		trace := &model.Trace{}
+		traceID := model.NewTraceID(g.r.Uint64(), g.r.Uint64())
+		g.span(trace, call, traceID, nil, model.ChildOf, start)
+		traces = append(traces, trace)
+	}
+	return traces
+}
+
+// span appends the spans of call started at start to trace and returns when it ended, and whether it failed
+func (g *fixtureGenerator) span(trace *model.Trace, call *fixtureCall, traceID model.TraceID, parent *model.Span,
+	refType model.SpanRefType, start time.Time) (time.Time, bool) {
+	process, podName := g.process(call.service)
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(g.r.Uint64()),
+		OperationName: call.operation,
+		StartTime:     start,
+		Tags:          []model.KeyValue{model.String("span.kind", "server")},
+		Process:       process,
+	}
+	if parent != nil {
+		span.References = []model.SpanRef{{TraceID: traceID, SpanID: parent.SpanID, RefType: refType}}
+	}
+	trace.Spans = append(trace.Spans, span)
+
+	self := time.Duration(float64(call.self) * math.Exp(fixtureJitter*g.r.NormFloat64()))
+	if g.options.SlowPod != "" && g.options.SlowPod == podName {
+		self = time.Duration(float64(self) * g.options.SlowFactor)
+	}
+
+	failed := g.options.FailingPod != "" && g.options.FailingPod == podName && g.r.Float64() < g.options.FailureRate
+
+	t := start.Add(self / 2)
+	for _, stage := range call.stages {
+		stageEnd := t
+		for _, c := range stage {
+			end, childFailed := g.span(trace, c, traceID, span, model.ChildOf, t.Add(g.gap()))
+			if end = end.Add(g.gap()); end.After(stageEnd) {
+				stageEnd = end
+			}
+			failed = failed || childFailed
+		}
+		t = stageEnd
+	}
+	end := t.Add(self - self/2)
+	span.Duration = end.Sub(start)
+	if failed {
+		span.Tags = append(span.Tags, model.Bool("error", true))
+	}
+
+	for _, c := range call.async {
+		g.span(trace, c, traceID, span, model.FollowsFrom, end.Add(g.gap()))
+	}
+	return end, failed
+}
+
+func (g *fixtureGenerator) gap() time.Duration {
+	return fixtureMinGap + time.Duration(g.r.Int63n(int64(fixtureMaxGap-fixtureMinGap)))
+}
+
+// process picks a replica of service, the processes are laid out like those of jaeger clients: version, hostname, ip
+func (g *fixtureGenerator) process(service string) (*model.Process, string) {
+	replica := g.r.Intn(g.options.PodsPerService)
+	podName := FixturePodName(service, replica)
+	if process, ok := g.processes[podName]; ok {
+		return process, podName
+	}
+	ip := fmt.Sprintf("10.244.%d.%d", len(g.processes)/250, len(g.processes)%250+2)
+	process := &model.Process{
+		ServiceName: service,
+		Tags: []model.KeyValue{
+			model.String("jaeger.version", "fixture"),
+			model.String("hostname", podName),
+			model.String("ip", ip),
+		},
+	}
+	g.processes[podName] = process
+	return process, podName
+}
@@ -2,45 +2,166 @@ package extractor
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jaegertracing/jaeger/model"
 )
 
+// EdgeKind is the reference type of an edge between two spans
+type EdgeKind int
+
+const (
+	// ChildOfEdge: the parent waits for the child
+	ChildOfEdge EdgeKind = iota
+	// FollowsFromEdge: the child is caused by the parent but the parent does not wait for it
+	FollowsFromEdge
+)
+
+func (k EdgeKind) String() string {
+	if k == FollowsFromEdge {
+		return "follows_from"
+	}
+	return "child_of"
+}
+
 type Span struct {
-	spanID    model.SpanID
-	podName   string
-	startTime time.Duration
-	duration  time.Duration
+	spanID  model.SpanID
+	podName string
+	// podResolved is false if the pod of the span is unknown, podName is empty then
+	podResolved   bool
+	serviceName   string
+	operationName string
+	startTime     time.Duration
+	duration      time.Duration
+	// duration minus the time covered by ChildOf children
+	selfTime time.Duration
+	// children are ChildOf the span, followers FollowsFrom it
 	children  []*Span
+	followers []*Span
 	parent    *Span
+	// kind of the edge from parent to the span
+	kind EdgeKind
+	// synthetic is set on the root added above the roots of a forest
+	synthetic bool
+}
+
+func (sp *Span) GetSpanID() model.SpanID {
+	return sp.spanID
 }
 
 func (sp *Span) GetPodName() string {
 	return sp.podName
 }
 
+// IsPodResolved tells whether the pod of the span is known
+func (sp *Span) IsPodResolved() bool {
+	return sp.podResolved
+}
+
+func (sp *Span) GetServiceName() string {
+	return sp.serviceName
+}
+
+func (sp *Span) GetOperationName() string {
+	return sp.operationName
+}
+
 func (sp *Span) GetDuration() time.Duration {
 	return sp.duration
 }
 
+// GetSelfTime returns the exclusive time of the span, not spent waiting on its children
+func (sp *Span) GetSelfTime() time.Duration {
+	return sp.selfTime
+}
+
+func (sp *Span) IsSynthetic() bool {
+	return sp.synthetic
+}
+
+// Edge is a reference between two spans of the graph. Every span hangs below one parent in the tree,
+// the extra references of multi-parent spans are only kept as edges
+type Edge struct {
+	Parent *Span
+	Child  *Span
+	Kind   EdgeKind
+}
+
+// Completeness tells how much of the trace NewGraph could connect
+type Completeness struct {
+	NumSpans int
+	// spans without any reference
+	NumRoots int
+	// spans below a span without any reference
+	NumConnected int
+	// spans whose referenced parents are all missing from the trace
+	NumOrphans int
+	// references to spans missing from the trace, or to the span itself
+	MissingParents int
+	// spans with more than one reference
+	MultiParentSpans int
+	FollowsFromEdges int
+	// spans with an ID already seen in the trace, dropped
+	DuplicateSpans int
+	// spans whose references form a cycle, cut into roots
+	CyclicSpans int
+	// spans whose pod could not be resolved, left out of the per-pod figures
+	UnresolvedPods int
+	// a synthetic root was added above several roots and orphans
+	SyntheticRoot bool
+}
+
+// Complete is true when the trace is a single tree of spans
+func (c Completeness) Complete() bool {
+	return c.NumRoots == 1 && c.NumOrphans == 0 && c.MissingParents == 0 && c.CyclicSpans == 0
+}
+
+// Ratio is the part of the spans connected to a real root
+func (c Completeness) Ratio() float64 {
+	if c.NumSpans == 0 {
+		return 0
+	}
+	return float64(c.NumConnected) / float64(c.NumSpans)
+}
+
+func (c Completeness) String() string {
+	return fmt.Sprintf("spans: %d, roots: %d, connected: %d, orphans: %d, missing parents: %d, multi-parent: %d, follows-from: %d, duplicates: %d, cyclic: %d, unresolved pods: %d",
+		c.NumSpans, c.NumRoots, c.NumConnected, c.NumOrphans, c.MissingParents, c.MultiParentSpans, c.FollowsFromEdges, c.DuplicateSpans, c.CyclicSpans, c.UnresolvedPods)
+}
+
 type Graph struct {
-	traceID     model.TraceID
-	root        *Span
-	startTime   time.Time
-	longestPath *Path
+	traceID      model.TraceID
+	root         *Span
+	startTime    time.Time
+	edges        []*Edge
+	completeness Completeness
+	criticalPath *Path
 }
 
+// PathNode is a segment of the critical path, the time between start and end the request was blocked on span itself
 type PathNode struct {
-	span *Span
-	next *PathNode
+	span  *Span
+	start time.Duration
+	end   time.Duration
+	next  *PathNode
 }
 
 func (p *PathNode) GetSpan() *Span {
 	return p.span
 }
 
+// GetStartTime returns the start of the segment, relative to the start of the trace
+func (p *PathNode) GetStartTime() time.Duration {
+	return p.start
+}
+
+// GetContribution returns what the segment adds to the end-to-end latency
+func (p *PathNode) GetContribution() time.Duration {
+	return p.end - p.start
+}
+
 func (p *PathNode) GetNext() *PathNode {
 	return p.next
 }
@@ -53,79 +174,208 @@ func (p *Path) GetHead() *PathNode {
 	return p.head
 }
 
-func NewGraph(trace *model.Trace) *Graph {
+// NewGraph builds the span tree of trace. ChildOf references are preferred over FollowsFrom ones to pick the parent,
+// spans without a parent in the trace become roots, and several roots are put below a synthetic root.
+// The pods of the spans are identified with the PodIdentifier of options.
+func NewGraph(trace *model.Trace, options AnalysisOptions) *Graph {
 	var graph Graph
 	spanMap := make(map[model.SpanID]*Span)
+	identifier := options.podIdentifier()
 
-	spans := trace.Spans
-	for _, span := range spans {
+	// the spans are linked in the order they started, not the order they were stored in, so which duplicate is kept,
+	// which edge of a cycle is cut and the order of the children do not depend on the store
+	sorted := make([]*model.Span, len(trace.Spans))
+	copy(sorted, trace.Spans)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].StartTime.Equal(sorted[j].StartTime) {
+			return sorted[i].StartTime.Before(sorted[j].StartTime)
+		}
+		return sorted[i].SpanID < sorted[j].SpanID
+	})
+
+	spans := make([]*model.Span, 0, len(sorted))
+	for _, span := range sorted {
+		if _, exists := spanMap[span.SpanID]; exists {
+			graph.completeness.DuplicateSpans++
+			continue
+		}
+		podName, podResolved := identifier.Identify(span)
+		if !podResolved {
+			graph.completeness.UnresolvedPods++
+		}
 		spanMap[span.SpanID] = &Span{
-			spanID:   span.SpanID,
-			podName:  span.Process.Tags[1].VStr,
-			duration: span.Duration,
-			children: make([]*Span, 0),
-			parent:   nil,
+			spanID:        span.SpanID,
+			podName:       podName,
+			podResolved:   podResolved,
+			operationName: span.OperationName,
+			duration:      span.Duration,
+			children:      make([]*Span, 0),
+			parent:        nil,
+		}
+		if span.Process != nil {
+			spanMap[span.SpanID].serviceName = span.Process.ServiceName
 		}
-		if span.TraceID.String() == span.SpanID.String() {
+		spans = append(spans, span)
+		if graph.startTime.IsZero() || span.StartTime.Before(graph.startTime) {
 			graph.traceID = span.TraceID
 			graph.startTime = span.StartTime
-			graph.root = spanMap[span.SpanID]
 		}
 	}
+	graph.completeness.NumSpans = len(spans)
+
+	roots := make([]*Span, 0, 1)
+	realRoots := make([]*Span, 0, 1)
+	for _, span := range spans {
+		curr := spanMap[span.SpanID]
+		curr.startTime = span.StartTime.Sub(graph.startTime)
+		references := traceReferences(span)
+		if len(references) == 0 {
+			graph.completeness.NumRoots++
+			roots = append(roots, curr)
+			realRoots = append(realRoots, curr)
+			continue
+		}
+		if len(references) > 1 {
+			graph.completeness.MultiParentSpans++
+		}
+
+		var parent *Span
+		kind := ChildOfEdge
+		for _, ref := range references {
+			refKind := ChildOfEdge
+			if ref.RefType == model.FollowsFrom {
+				refKind = FollowsFromEdge
+				graph.completeness.FollowsFromEdges++
+			}
+			p, ok := spanMap[ref.SpanID]
+			if !ok || p == curr {
+				graph.completeness.MissingParents++
+				continue
+			}
+			graph.edges = append(graph.edges, &Edge{Parent: p, Child: curr, Kind: refKind})
+			if parent == nil || kind == FollowsFromEdge && refKind == ChildOfEdge {
+				parent = p
+				kind = refKind
+			}
+		}
+		if parent == nil {
+			graph.completeness.NumOrphans++
+			roots = append(roots, curr)
+			continue
+		}
+		curr.parent = parent
+		curr.kind = kind
+	}
+
+	// references may form cycles, which no root reaches
+	for _, span := range spans {
+		curr := spanMap[span.SpanID]
+		if curr.parent == nil || reachesRoot(curr) {
+			continue
+		}
+		curr.parent = nil
+		graph.completeness.CyclicSpans++
+		roots = append(roots, curr)
+	}
 
 	for _, span := range spans {
-		spanMap[span.SpanID].startTime = span.StartTime.Sub(graph.startTime)
-		ref := span.References[0]
-		if ref.RefType == model.ChildOf {
-			spanMap[span.SpanID].parent = spanMap[ref.SpanID]
-			spanMap[ref.SpanID].children = append(spanMap[ref.SpanID].children, spanMap[span.SpanID])
+		curr := spanMap[span.SpanID]
+		if curr.parent == nil {
+			continue
+		}
+		if curr.kind == FollowsFromEdge {
+			curr.parent.followers = append(curr.parent.followers, curr)
+		} else {
+			curr.parent.children = append(curr.parent.children, curr)
 		}
 	}
 
-	graph.longestPath = graph.buildLongestPath()
+	for _, r := range realRoots {
+		doPrint(r, 0, func(int, *Span) {
+			graph.completeness.NumConnected++
+		})
+	}
+
+	switch len(roots) {
+	case 0:
+	case 1:
+		graph.root = roots[0]
+	default:
+		graph.root = newSyntheticRoot(roots)
+		graph.completeness.SyntheticRoot = true
+	}
+
+	for _, sp := range spanMap {
+		sp.selfTime = selfTime(sp)
+	}
+	graph.criticalPath = graph.buildCriticalPath()
 
 	return &graph
 }
 
-func (g *Graph) buildLongestPath() *Path {
-	path := &Path{
-		head: &PathNode{
-			span: g.root,
-			next: nil,
-		},
+// reachesRoot walks up the parents of sp, a cycle never gets to a span without parent
+func reachesRoot(sp *Span) bool {
+	slow, fast := sp, sp
+	for fast != nil && fast.parent != nil {
+		slow = slow.parent
+		fast = fast.parent.parent
+		if slow == fast {
+			return false
+		}
 	}
+	return true
+}
 
-	curr := g.root
-	currPathNode := path.head
-	for curr.children != nil {
-		maxChild := curr
-		maxDuration := 0
-		for _, child := range curr.children {
-			if maxDuration < int(child.duration) {
-				maxDuration = int(child.duration)
-				maxChild = child
-			}
-		}
-		curr = maxChild
-		currPathNode.next = &PathNode{
-			span: curr,
-			next: nil,
+// newSyntheticRoot spans all of the roots, which become its ChildOf children in the order they started
+func newSyntheticRoot(roots []*Span) *Span {
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].startTime < roots[j].startTime
+	})
+	root := &Span{
+		startTime: roots[0].startTime,
+		children:  make([]*Span, 0, len(roots)),
+		synthetic: true,
+	}
+	var end time.Duration
+	for _, r := range roots {
+		r.parent = root
+		r.kind = ChildOfEdge
+		root.children = append(root.children, r)
+		if r.startTime+r.duration > end {
+			end = r.startTime + r.duration
 		}
-		currPathNode = currPathNode.next
 	}
-	return path
+	root.duration = end - root.startTime
+	return root
 }
 
-func (g *Graph) PrintLongestPath() {
-	if g.longestPath == nil {
-		g.longestPath = g.buildLongestPath()
+// rootSpan returns the earliest span without references into its trace, nil if there is none
+func rootSpan(trace *model.Trace) *model.Span {
+	var root *model.Span
+	for _, span := range trace.Spans {
+		if len(traceReferences(span)) == 0 && (root == nil || span.StartTime.Before(root.StartTime)) {
+			root = span
+		}
 	}
-	curr := g.longestPath.head
-	for curr != nil {
-		span := curr.span
-		fmt.Println(span.podName, span.startTime, span.duration)
-		curr = curr.next
+	return root
+}
+
+// traceReferences returns the references of span to spans of its own trace, the links of OTLP spans become
+// FollowsFrom references which may point into other traces
+func traceReferences(span *model.Span) []model.SpanRef {
+	for _, ref := range span.References {
+		if ref.TraceID == span.TraceID {
+			continue
+		}
+		refs := make([]model.SpanRef, 0, len(span.References)-1)
+		for _, ref := range span.References {
+			if ref.TraceID == span.TraceID {
+				refs = append(refs, ref)
+			}
+		}
+		return refs
 	}
+	return span.References
 }
 
 func doPrint(currSpan *Span, level int, printFunc func(int, *Span)) {
@@ -133,14 +383,51 @@ func doPrint(currSpan *Span, level int, printFunc func(int, *Span)) {
 	for _, child := range currSpan.children {
 		doPrint(child, level+1, printFunc)
 	}
+	for _, follower := range currSpan.followers {
+		doPrint(follower, level+1, printFunc)
+	}
 }
 
 func (g *Graph) PrintGraph() {
+	if g.root == nil {
+		return
+	}
 	doPrint(g.root, 0, func(level int, span *Span) {
-		fmt.Println(strings.Repeat("  ", level), span.podName, span.startTime, span.duration)
+		name := span.podName
+		if span.synthetic {
+			name = "<root>"
+		} else if span.kind == FollowsFromEdge {
+			name = "~> " + name
+		}
+		fmt.Println(strings.Repeat("  ", level), name, span.operationName, span.startTime, span.duration)
+	})
+}
+
+func (g *Graph) GetCriticalPath() *Path {
+	return g.criticalPath
+}
+
+// GetRoot returns the root span, synthetic when the trace has several roots, nil for an empty trace
+func (g *Graph) GetRoot() *Span {
+	return g.root
+}
+
+// GetEdges returns every reference between spans of the trace, including the extra ones of multi-parent spans
+func (g *Graph) GetEdges() []*Edge {
+	return g.edges
+}
+
+// GetUnresolvedSpans returns the spans whose pod is unknown
+func (g *Graph) GetUnresolvedSpans() []*Span {
+	spans := make([]*Span, 0, g.completeness.UnresolvedPods)
+	g.walk(func(sp *Span) {
+		if !sp.podResolved {
+			spans = append(spans, sp)
+		}
 	})
+	return spans
 }
 
-func (g *Graph) GetLongestPath() *Path {
-	return g.longestPath
+func (g *Graph) GetCompleteness() Completeness {
+	return g.completeness
 }
@@ -0,0 +1,279 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+var testTraceStart = time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+// testSpan is a span of a hand-built trace, started start ms after testTraceStart and lasting duration ms.
+// Its pod is service-0, unless the service is empty
+type testSpan struct {
+	id       uint64
+	service  string
+	start    int
+	duration int
+	refs     []model.SpanRef
+}
+
+func buildTestTrace(spans ...testSpan) *model.Trace {
+	traceID := model.NewTraceID(1, 1)
+	trace := &model.Trace{}
+	for _, s := range spans {
+		process := model.NewProcess(s.service, nil)
+		if s.service != "" {
+			process.Tags = []model.KeyValue{model.String("hostname", s.service+"-0")}
+		}
+		refs := make([]model.SpanRef, len(s.refs))
+		for i, ref := range s.refs {
+			ref.TraceID = traceID
+			refs[i] = ref
+		}
+		trace.Spans = append(trace.Spans, &model.Span{
+			TraceID:       traceID,
+			SpanID:        model.NewSpanID(s.id),
+			OperationName: "op",
+			References:    refs,
+			StartTime:     testTraceStart.Add(time.Duration(s.start) * time.Millisecond),
+			Duration:      time.Duration(s.duration) * time.Millisecond,
+			Process:       process,
+		})
+	}
+	return trace
+}
+
+func childOf(id uint64) model.SpanRef {
+	return model.SpanRef{SpanID: model.NewSpanID(id), RefType: model.ChildOf}
+}
+
+func followsFrom(id uint64) model.SpanRef {
+	return model.SpanRef{SpanID: model.NewSpanID(id), RefType: model.FollowsFrom}
+}
+
+// findSpan returns the span of the graph with id, nil if it is not below the root
+func findSpan(g *Graph, id uint64) *Span {
+	var found *Span
+	g.walk(func(sp *Span) {
+		if sp.spanID == model.NewSpanID(id) {
+			found = sp
+		}
+	})
+	return found
+}
+
+func spanIDs(spans []*Span) []uint64 {
+	ids := make([]uint64, 0, len(spans))
+	for _, sp := range spans {
+		ids = append(ids, uint64(sp.spanID))
+	}
+	return ids
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNewGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		want  Completeness
+		check func(t *testing.T, g *Graph)
+	}{
+		{
+			name: "tree",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 2, 2, []model.SpanRef{childOf(2)}},
+			),
+			want: Completeness{NumSpans: 3, NumRoots: 1, NumConnected: 3},
+			check: func(t *testing.T, g *Graph) {
+				if g.GetRoot().spanID != 1 || g.GetRoot().IsSynthetic() {
+					t.Errorf("root is %s", g.GetRoot().spanID)
+				}
+				if !g.GetCompleteness().Complete() || g.GetCompleteness().Ratio() != 1 {
+					t.Errorf("tree is not complete")
+				}
+				if parent := findSpan(g, 3).parent; parent == nil || parent.spanID != 2 {
+					t.Errorf("parent of 3 is %v", parent)
+				}
+				if len(g.GetEdges()) != 2 {
+					t.Errorf("%d edges, want 2", len(g.GetEdges()))
+				}
+			},
+		},
+		{
+			name: "follows from",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(1)}},
+				testSpan{3, "queue", 8, 5, []model.SpanRef{followsFrom(1)}},
+			),
+			want: Completeness{NumSpans: 3, NumRoots: 1, NumConnected: 3, FollowsFromEdges: 1},
+			check: func(t *testing.T, g *Graph) {
+				root := g.GetRoot()
+				if !equalIDs(spanIDs(root.children), []uint64{2}) || !equalIDs(spanIDs(root.followers), []uint64{3}) {
+					t.Errorf("children of the root are %v and followers %v", spanIDs(root.children), spanIDs(root.followers))
+				}
+				if findSpan(g, 3).kind != FollowsFromEdge {
+					t.Error("3 is not a follower")
+				}
+			},
+		},
+		{
+			name: "several roots",
+			trace: buildTestTrace(
+				testSpan{2, "backend", 5, 5, nil},
+				testSpan{1, "frontend", 0, 3, nil},
+			),
+			want: Completeness{NumSpans: 2, NumRoots: 2, NumConnected: 2, SyntheticRoot: true},
+			check: func(t *testing.T, g *Graph) {
+				root := g.GetRoot()
+				if !root.IsSynthetic() {
+					t.Fatal("root is not synthetic")
+				}
+				// in the order they started, spanning them all
+				if !equalIDs(spanIDs(root.children), []uint64{1, 2}) {
+					t.Errorf("children of the synthetic root are %v", spanIDs(root.children))
+				}
+				if root.GetDuration() != 10*time.Millisecond {
+					t.Errorf("synthetic root lasts %v, want 10ms", root.GetDuration())
+				}
+			},
+		},
+		{
+			name: "orphan",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(9)}},
+			),
+			want: Completeness{NumSpans: 2, NumRoots: 1, NumConnected: 1, NumOrphans: 1, MissingParents: 1, SyntheticRoot: true},
+			check: func(t *testing.T, g *Graph) {
+				if !equalIDs(spanIDs(g.GetRoot().children), []uint64{1, 2}) {
+					t.Errorf("children of the synthetic root are %v", spanIDs(g.GetRoot().children))
+				}
+				if g.GetCompleteness().Complete() || g.GetCompleteness().Ratio() != 0.5 {
+					t.Errorf("orphan is complete")
+				}
+			},
+		},
+		{
+			name: "only orphans",
+			trace: buildTestTrace(
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(9)}},
+			),
+			want: Completeness{NumSpans: 1, NumOrphans: 1, MissingParents: 1},
+			check: func(t *testing.T, g *Graph) {
+				if g.GetRoot() == nil || g.GetRoot().spanID != 2 {
+					t.Errorf("root is %v, want the orphan", g.GetRoot())
+				}
+			},
+		},
+		{
+			name: "multiple parents",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 2, 2, []model.SpanRef{followsFrom(1), childOf(2)}},
+			),
+			want: Completeness{NumSpans: 3, NumRoots: 1, NumConnected: 3, MultiParentSpans: 1, FollowsFromEdges: 1},
+			check: func(t *testing.T, g *Graph) {
+				// ChildOf is preferred, the other reference is only an edge
+				sp := findSpan(g, 3)
+				if sp.parent.spanID != 2 || sp.kind != ChildOfEdge {
+					t.Errorf("parent of 3 is %s by %s, want 2 by child_of", sp.parent.spanID, sp.kind)
+				}
+				if len(g.GetEdges()) != 3 {
+					t.Errorf("%d edges, want 3", len(g.GetEdges()))
+				}
+			},
+		},
+		{
+			name: "self reference",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(2)}},
+			),
+			want: Completeness{NumSpans: 2, NumRoots: 1, NumConnected: 1, NumOrphans: 1, MissingParents: 1, SyntheticRoot: true},
+		},
+		{
+			name: "cycle",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 5, []model.SpanRef{childOf(3)}},
+				testSpan{3, "db", 2, 2, []model.SpanRef{childOf(2)}},
+			),
+			want: Completeness{NumSpans: 3, NumRoots: 1, NumConnected: 1, CyclicSpans: 1, SyntheticRoot: true},
+			check: func(t *testing.T, g *Graph) {
+				// the edge into the first span started is cut
+				if sp := findSpan(g, 2); sp.parent != g.GetRoot() {
+					t.Errorf("parent of 2 is %v, want the synthetic root", sp.parent)
+				}
+				if sp := findSpan(g, 3); sp.parent == nil || sp.parent.spanID != 2 {
+					t.Errorf("parent of 3 is %v, want 2", sp.parent)
+				}
+			},
+		},
+		{
+			name: "duplicate",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 3, 5, []model.SpanRef{childOf(1)}},
+				testSpan{2, "backend", 1, 4, []model.SpanRef{childOf(1)}},
+			),
+			want: Completeness{NumSpans: 2, NumRoots: 1, NumConnected: 2, DuplicateSpans: 1},
+			check: func(t *testing.T, g *Graph) {
+				// the one started first is kept
+				if sp := findSpan(g, 2); sp.GetDuration() != 4*time.Millisecond {
+					t.Errorf("kept the duplicate lasting %v", sp.GetDuration())
+				}
+			},
+		},
+		{
+			name: "unresolved pod",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "", 1, 5, []model.SpanRef{childOf(1)}},
+			),
+			want: Completeness{NumSpans: 2, NumRoots: 1, NumConnected: 2, UnresolvedPods: 1},
+			check: func(t *testing.T, g *Graph) {
+				if unresolved := g.GetUnresolvedSpans(); !equalIDs(spanIDs(unresolved), []uint64{2}) {
+					t.Errorf("unresolved spans are %v", spanIDs(unresolved))
+				}
+			},
+		},
+		{
+			name:  "empty",
+			trace: &model.Trace{},
+			want:  Completeness{},
+			check: func(t *testing.T, g *Graph) {
+				if g.GetRoot() != nil {
+					t.Error("empty trace has a root")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph(tt.trace, AnalysisOptions{})
+			if got := g.GetCompleteness(); got != tt.want {
+				t.Errorf("completeness is\n%+v, want\n%+v", got, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package extractor
+
+import (
+	"net"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// DefaultPodTagKeys are tried in order, jaeger clients in a pod report its name as the hostname
+var DefaultPodTagKeys = []string{"hostname", "k8s.pod.name", "ip"}
+
+// PodResolver maps the IP of a pod to its name
+type PodResolver interface {
+	ResolvePod(ip string) (string, bool)
+}
+
+// PodIdentifier resolves the pod of a span by the keys of its tags rather than their position
+type PodIdentifier struct {
+	tagKeys  []string
+	resolver PodResolver
+}
+
+// NewPodIdentifier looks the tagKeys up in the process tags, then in the span tags. A value which is an IP
+// is resolved to a pod name with resolver if not nil, and taken as the identity itself otherwise
+func NewPodIdentifier(tagKeys []string, resolver PodResolver) *PodIdentifier {
+	return &PodIdentifier{
+		tagKeys:  tagKeys,
+		resolver: resolver,
+	}
+}
+
+// Identify returns the pod of span, false if none of the tags resolves to one
+func (pi *PodIdentifier) Identify(span *model.Span) (string, bool) {
+	for _, key := range pi.tagKeys {
+		var values []string
+		if span.Process != nil {
+			values = append(values, tagValues(span.Process.Tags, key)...)
+		}
+		values = append(values, tagValues(span.Tags, key)...)
+
+		for _, value := range values {
+			if net.ParseIP(value) == nil {
+				return value, true
+			}
+			if pi.resolver == nil {
+				return value, true
+			}
+			if name, ok := pi.resolver.ResolvePod(value); ok {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func tagValues(kvs []model.KeyValue, key string) []string {
+	values := make([]string, 0)
+	for _, kv := range kvs {
+		if kv.Key == key {
+			if value := kv.AsString(); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,81 @@
+package extractor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultPodListInterval = 5 * time.Second
+	defaultPodListTimeout  = 5 * time.Second
+)
+
+// KubernetesPodResolver maps pod IPs to pod names by listing the pods of a namespace. A miss starts a refresh
+// of the list in the background, at most every defaultPodListInterval, and is reported unresolved meanwhile,
+// so the graphs are never built waiting on the API server
+type KubernetesPodResolver struct {
+	clientset kubernetes.Interface
+	namespace string
+
+	mu         sync.RWMutex
+	pods       map[string]string
+	listed     time.Time
+	refreshing bool
+}
+
+func NewKubernetesPodResolver(clientset kubernetes.Interface, namespace string) *KubernetesPodResolver {
+	return &KubernetesPodResolver{
+		clientset: clientset,
+		namespace: namespace,
+		pods:      make(map[string]string),
+	}
+}
+
+func (r *KubernetesPodResolver) ResolvePod(ip string) (string, bool) {
+	r.mu.RLock()
+	name, ok := r.pods[ip]
+	r.mu.RUnlock()
+	if ok {
+		return name, true
+	}
+
+	r.mu.Lock()
+	if !r.refreshing && time.Since(r.listed) >= defaultPodListInterval {
+		r.refreshing = true
+		r.listed = time.Now()
+		go r.refresh()
+	}
+	r.mu.Unlock()
+	return "", false
+}
+
+// refresh lists the pods without holding the lock, and swaps the map in if it succeeded
+func (r *KubernetesPodResolver) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultPodListTimeout)
+	defer cancel()
+	podList, err := r.clientset.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{})
+
+	var pods map[string]string
+	if err != nil {
+		log.Printf("failed to list the pods of %s: %v", r.namespace, err)
+	} else {
+		pods = make(map[string]string, len(podList.Items))
+		for _, pod := range podList.Items {
+			if pod.Status.PodIP != "" {
+				pods[pod.Status.PodIP] = pod.Name
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshing = false
+	if pods != nil {
+		r.pods = pods
+	}
+}
@@ -0,0 +1,37 @@
+package extractor
+
+import (
+	"github.com/jaegertracing/jaeger/model"
+)
+
+var (
+	defaultPodIdentifier   = NewPodIdentifier(DefaultPodTagKeys, nil)
+	defaultSamplingWeigher = NewSamplingWeigher(nil)
+)
+
+// AnalysisOptions tell the graphs and figures of the extractor how to identify the pod of a span and how many
+// requests a trace stands for. The zero value identifies pods by DefaultPodTagKeys without a resolver,
+// and weights traces by their sampler tags without request rates.
+type AnalysisOptions struct {
+	PodIdentifier   *PodIdentifier
+	SamplingWeigher *SamplingWeigher
+}
+
+func (o AnalysisOptions) podIdentifier() *PodIdentifier {
+	if o.PodIdentifier == nil {
+		return defaultPodIdentifier
+	}
+	return o.PodIdentifier
+}
+
+func (o AnalysisOptions) samplingWeigher() *SamplingWeigher {
+	if o.SamplingWeigher == nil {
+		return defaultSamplingWeigher
+	}
+	return o.SamplingWeigher
+}
+
+// TraceWeight weights trace with the SamplingWeigher of the options
+func (o AnalysisOptions) TraceWeight(trace *model.Trace) float64 {
+	return o.samplingWeigher().Weight(trace)
+}
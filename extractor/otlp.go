@@ -0,0 +1,302 @@
+package extractor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+	"google.golang.org/grpc"
+)
+
+const (
+	unknownServiceName = "unknown_service"
+
+	otlpTracesPath       = "/v1/traces"
+	contentTypeProtobuf  = "application/x-protobuf"
+	contentTypeJSON      = "application/json"
+	otlpServiceName      = "service.name"
+	otlpStatusCode       = "otel.status_code"
+	otlpStatusMessage    = "otel.status_description"
+	otlpLibraryName      = "otel.library.name"
+	otlpDefaultEventName = "event"
+)
+
+var (
+	// resource attributes identifying the pod, the first one present becomes the hostname tag
+	otlpHostnameKeys = []string{"k8s.pod.name", "host.name"}
+	otlpIPKeys       = []string{"k8s.pod.ip", "net.host.ip"}
+)
+
+// OTLPReceiver accepts OTLP/HTTP (protobuf and JSON) and OTLP/gRPC exports and keeps the spans in
+// a TraceWindow, so the extractor can run on services instrumented with OpenTelemetry without Jaeger
+type OTLPReceiver struct {
+	window     *TraceWindow
+	httpServer *http.Server
+	grpcServer *grpc.Server
+
+	pbUnmarshaler   pdata.TracesUnmarshaler
+	jsonUnmarshaler pdata.TracesUnmarshaler
+}
+
+func NewOTLPReceiver(window *TraceWindow) *OTLPReceiver {
+	return &OTLPReceiver{
+		window:          window,
+		pbUnmarshaler:   otlp.NewProtobufTracesUnmarshaler(),
+		jsonUnmarshaler: otlp.NewJSONTracesUnmarshaler(),
+	}
+}
+
+// Start serves OTLP/HTTP on httpAddress and OTLP/gRPC on grpcAddress, an empty address disables it
+func (r *OTLPReceiver) Start(httpAddress, grpcAddress string) error {
+	if httpAddress != "" {
+		lis, err := net.Listen("tcp", httpAddress)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle(otlpTracesPath, r)
+		r.httpServer = &http.Server{Handler: mux}
+		go func() {
+			if err := r.httpServer.Serve(lis); err != nil && err != http.ErrServerClosed {
+				log.Printf("OTLP/HTTP receiver stopped: %v", err)
+			}
+		}()
+	}
+
+	if grpcAddress != "" {
+		lis, err := net.Listen("tcp", grpcAddress)
+		if err != nil {
+			return err
+		}
+		r.grpcServer = grpc.NewServer()
+		otlpgrpc.RegisterTracesServer(r.grpcServer, r)
+		go func() {
+			if err := r.grpcServer.Serve(lis); err != nil {
+				log.Printf("OTLP/gRPC receiver stopped: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (r *OTLPReceiver) Close() {
+	if r.httpServer != nil {
+		r.httpServer.Close()
+	}
+	if r.grpcServer != nil {
+		r.grpcServer.Stop()
+	}
+}
+
+// Export implements otlpgrpc.TracesServer
+func (r *OTLPReceiver) Export(_ context.Context, request otlpgrpc.TracesRequest) (otlpgrpc.TracesResponse, error) {
+	r.window.AddSpans(OTLPToSpans(request.Traces()))
+	return otlpgrpc.NewTracesResponse(), nil
+}
+
+func (r *OTLPReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	var unmarshaler pdata.TracesUnmarshaler
+	switch {
+	case strings.HasPrefix(contentType, contentTypeProtobuf):
+		unmarshaler = r.pbUnmarshaler
+	case strings.HasPrefix(contentType, contentTypeJSON):
+		unmarshaler = r.jsonUnmarshaler
+	default:
+		http.Error(w, fmt.Sprintf("unsupported content type: %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	td, err := unmarshaler.UnmarshalTraces(buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.window.AddSpans(OTLPToSpans(td))
+
+	response := otlpgrpc.NewTracesResponse()
+	var out []byte
+	if unmarshaler == r.jsonUnmarshaler {
+		out, err = response.MarshalJSON()
+	} else {
+		out, err = response.Marshal()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// OTLPToSpans converts OTLP spans into the jaeger spans NewGraph and the rest of the extractor consume
+func OTLPToSpans(td pdata.Traces) []*model.Span {
+	spans := make([]*model.Span, 0, td.SpanCount())
+	resourceSpans := td.ResourceSpans()
+	for i := 0; i < resourceSpans.Len(); i++ {
+		rs := resourceSpans.At(i)
+		process := otlpResourceToProcess(rs.Resource())
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			ils := ilss.At(j)
+			library := ils.InstrumentationLibrary().Name()
+			otlpSpans := ils.Spans()
+			for k := 0; k < otlpSpans.Len(); k++ {
+				span := otlpSpanToSpan(otlpSpans.At(k), process)
+				if library != "" {
+					span.Tags = append(span.Tags, model.String(otlpLibraryName, library))
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans
+}
+
+// otlpResourceToProcess lays the process tags out like jaeger clients do: version, hostname, ip,
+// followed by the remaining resource attributes
+func otlpResourceToProcess(resource pdata.Resource) *model.Process {
+	attributes := resource.Attributes()
+
+	serviceName := unknownServiceName
+	if v, ok := attributes.Get(otlpServiceName); ok && v.StringVal() != "" {
+		serviceName = v.StringVal()
+	}
+	lookup := func(keys []string) string {
+		for _, key := range keys {
+			if v, ok := attributes.Get(key); ok {
+				return v.AsString()
+			}
+		}
+		return ""
+	}
+
+	tags := []model.KeyValue{
+		model.String("jaeger.version", "otlp"),
+		model.String("hostname", lookup(otlpHostnameKeys)),
+		model.String("ip", lookup(otlpIPKeys)),
+	}
+	attributes.Range(func(k string, v pdata.AttributeValue) bool {
+		if k != otlpServiceName {
+			tags = append(tags, otlpAttributeToKeyValue(k, v))
+		}
+		return true
+	})
+	// model.NewProcess would sort the tags
+	return &model.Process{ServiceName: serviceName, Tags: tags}
+}
+
+func otlpSpanToSpan(s pdata.Span, process *model.Process) *model.Span {
+	traceID := s.TraceID().Bytes()
+	spanID := s.SpanID().Bytes()
+	span := &model.Span{
+		TraceID:       otlpTraceID(traceID),
+		SpanID:        otlpSpanID(spanID),
+		OperationName: s.Name(),
+		StartTime:     s.StartTimestamp().AsTime(),
+		Duration:      s.EndTimestamp().AsTime().Sub(s.StartTimestamp().AsTime()),
+		Process:       process,
+	}
+
+	if !s.ParentSpanID().IsEmpty() {
+		span.References = append(span.References, model.NewChildOfRef(span.TraceID, otlpSpanID(s.ParentSpanID().Bytes())))
+	}
+	links := s.Links()
+	for i := 0; i < links.Len(); i++ {
+		link := links.At(i)
+		span.References = append(span.References,
+			model.NewFollowsFromRef(otlpTraceID(link.TraceID().Bytes()), otlpSpanID(link.SpanID().Bytes())))
+	}
+
+	s.Attributes().Range(func(k string, v pdata.AttributeValue) bool {
+		span.Tags = append(span.Tags, otlpAttributeToKeyValue(k, v))
+		return true
+	})
+	if s.Kind() != pdata.SpanKindUnspecified {
+		kind := strings.ToLower(strings.TrimPrefix(s.Kind().String(), "SPAN_KIND_"))
+		span.Tags = append(span.Tags, model.String("span.kind", kind))
+	}
+	switch s.Status().Code() {
+	case pdata.StatusCodeError:
+		span.Tags = append(span.Tags, model.Bool("error", true), model.String(otlpStatusCode, "ERROR"))
+	case pdata.StatusCodeOk:
+		span.Tags = append(span.Tags, model.String(otlpStatusCode, "OK"))
+	}
+	if msg := s.Status().Message(); msg != "" {
+		span.Tags = append(span.Tags, model.String(otlpStatusMessage, msg))
+	}
+
+	events := s.Events()
+	for i := 0; i < events.Len(); i++ {
+		event := events.At(i)
+		fields := make([]model.KeyValue, 0, event.Attributes().Len()+1)
+		if event.Name() != "" {
+			fields = append(fields, model.String(otlpDefaultEventName, event.Name()))
+		}
+		event.Attributes().Range(func(k string, v pdata.AttributeValue) bool {
+			fields = append(fields, otlpAttributeToKeyValue(k, v))
+			return true
+		})
+		span.Logs = append(span.Logs, model.Log{
+			Timestamp: event.Timestamp().AsTime(),
+			Fields:    fields,
+		})
+	}
+	return span
+}
+
+func otlpAttributeToKeyValue(k string, v pdata.AttributeValue) model.KeyValue {
+	switch v.Type() {
+	case pdata.AttributeValueTypeString:
+		return model.String(k, v.StringVal())
+	case pdata.AttributeValueTypeInt:
+		return model.Int64(k, v.IntVal())
+	case pdata.AttributeValueTypeDouble:
+		return model.Float64(k, v.DoubleVal())
+	case pdata.AttributeValueTypeBool:
+		return model.Bool(k, v.BoolVal())
+	default:
+		return model.String(k, v.AsString())
+	}
+}
+
+func otlpTraceID(b [16]byte) model.TraceID {
+	return bytesToTraceID(b[:])
+}
+
+func otlpSpanID(b [8]byte) model.SpanID {
+	return model.NewSpanID(binary.BigEndian.Uint64(b[:]))
+}
@@ -0,0 +1,185 @@
+package extractor
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+var (
+	otlpTestTraceID = [16]byte{15: 1}
+	otlpOtherTrace  = [16]byte{15: 2}
+)
+
+func otlpTestSpanID(id byte) [8]byte {
+	return [8]byte{7: id}
+}
+
+// buildOTLPTraces returns a request of frontend calling post-storage-service, which fails, and a span of
+// frontend linked to another trace
+func buildOTLPTraces(start time.Time) pdata.Traces {
+	td := pdata.NewTraces()
+
+	frontend := td.ResourceSpans().AppendEmpty()
+	frontend.Resource().Attributes().InsertString("service.name", "frontend")
+	frontend.Resource().Attributes().InsertString("k8s.pod.name", "frontend-0")
+	frontend.Resource().Attributes().InsertString("k8s.pod.ip", "10.244.0.2")
+	ils := frontend.InstrumentationLibrarySpans().AppendEmpty()
+	ils.InstrumentationLibrary().SetName("net/http")
+
+	root := ils.Spans().AppendEmpty()
+	root.SetTraceID(pdata.NewTraceID(otlpTestTraceID))
+	root.SetSpanID(pdata.NewSpanID(otlpTestSpanID(1)))
+	root.SetName("GET /home")
+	root.SetKind(pdata.SpanKindServer)
+	root.SetStartTimestamp(pdata.NewTimestampFromTime(start))
+	root.SetEndTimestamp(pdata.NewTimestampFromTime(start.Add(10 * time.Millisecond)))
+	root.Attributes().InsertInt("http.status_code", 200)
+	root.Status().SetCode(pdata.StatusCodeOk)
+	// the batch that triggered the request
+	link := root.Links().AppendEmpty()
+	link.SetTraceID(pdata.NewTraceID(otlpOtherTrace))
+	link.SetSpanID(pdata.NewSpanID(otlpTestSpanID(9)))
+
+	storage := td.ResourceSpans().AppendEmpty()
+	storage.Resource().Attributes().InsertString("service.name", "post-storage-service")
+	storage.Resource().Attributes().InsertString("host.name", "post-storage-service-0")
+	child := storage.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	child.SetTraceID(pdata.NewTraceID(otlpTestTraceID))
+	child.SetSpanID(pdata.NewSpanID(otlpTestSpanID(2)))
+	child.SetParentSpanID(pdata.NewSpanID(otlpTestSpanID(1)))
+	child.SetName("read_posts")
+	child.SetStartTimestamp(pdata.NewTimestampFromTime(start.Add(2 * time.Millisecond)))
+	child.SetEndTimestamp(pdata.NewTimestampFromTime(start.Add(8 * time.Millisecond)))
+	child.Attributes().InsertDouble("ratio", 0.5)
+	child.Status().SetCode(pdata.StatusCodeError)
+	child.Status().SetMessage("timeout")
+	event := child.Events().AppendEmpty()
+	event.SetName("cache miss")
+	event.SetTimestamp(pdata.NewTimestampFromTime(start.Add(3 * time.Millisecond)))
+	event.Attributes().InsertBool("retry", true)
+	return td
+}
+
+func TestOTLPToSpans(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	spans := OTLPToSpans(buildOTLPTraces(start))
+	if len(spans) != 2 {
+		t.Fatalf("converted %d spans, want 2", len(spans))
+	}
+	root, child := spans[0], spans[1]
+	traceID := model.NewTraceID(0, 1)
+
+	if root.TraceID != traceID || root.SpanID != 1 || root.OperationName != "GET /home" ||
+		!root.StartTime.Equal(start) || root.Duration != 10*time.Millisecond {
+		t.Errorf("root span is %v", root)
+	}
+	if root.Process.ServiceName != "frontend" {
+		t.Errorf("process is %v", root.Process)
+	}
+	if hostname, _ := model.KeyValues(root.Process.Tags).FindByKey("hostname"); hostname.AsString() != "frontend-0" {
+		t.Errorf("hostname is %v", hostname)
+	}
+	if ip, _ := model.KeyValues(root.Process.Tags).FindByKey("ip"); ip.AsString() != "10.244.0.2" {
+		t.Errorf("ip is %v", ip)
+	}
+	for key, want := range map[string]string{"span.kind": "server", otlpStatusCode: "OK", otlpLibraryName: "net/http", "http.status_code": "200"} {
+		if kv, ok := model.KeyValues(root.Tags).FindByKey(key); !ok || kv.AsString() != want {
+			t.Errorf("tag %s of the root is %v, want %s", key, kv, want)
+		}
+	}
+	// links become FollowsFrom references, into other traces
+	if len(root.References) != 1 || root.References[0].RefType != model.FollowsFrom ||
+		root.References[0].TraceID != model.NewTraceID(0, 2) || root.References[0].SpanID != 9 {
+		t.Errorf("references of the root are %v", root.References)
+	}
+
+	if len(child.References) != 1 || child.References[0].RefType != model.ChildOf ||
+		child.References[0].TraceID != traceID || child.References[0].SpanID != 1 {
+		t.Errorf("references of the child are %v", child.References)
+	}
+	if hostname, _ := model.KeyValues(child.Process.Tags).FindByKey("hostname"); hostname.AsString() != "post-storage-service-0" {
+		t.Errorf("hostname of the child is %v", hostname)
+	}
+	if !IsSpanFailed(child) {
+		t.Error("child is not failed")
+	}
+	if msg, _ := model.KeyValues(child.Tags).FindByKey(otlpStatusMessage); msg.AsString() != "timeout" {
+		t.Errorf("status message is %v", msg)
+	}
+	if ratio, _ := model.KeyValues(child.Tags).FindByKey("ratio"); ratio.VType != model.Float64Type || ratio.Float64() != 0.5 {
+		t.Errorf("ratio is %v", ratio)
+	}
+	if len(child.Logs) != 1 || !child.Logs[0].Timestamp.Equal(start.Add(3*time.Millisecond)) ||
+		child.Logs[0].Fields[0].AsString() != "cache miss" || child.Logs[0].Fields[1].AsString() != "true" {
+		t.Errorf("logs are %v", child.Logs)
+	}
+
+	// the link into another trace does not make the root an orphan
+	trace := &model.Trace{Spans: spans}
+	if rootSpan(trace) != root {
+		t.Errorf("root span is %v", rootSpan(trace))
+	}
+	g := NewGraph(trace, AnalysisOptions{})
+	if completeness := g.GetCompleteness(); !completeness.Complete() || completeness.NumConnected != 2 {
+		t.Errorf("trace is not a single tree: %s", completeness)
+	}
+	if g.GetRoot().GetPodName() != "frontend-0" {
+		t.Errorf("root is %v", g.GetRoot())
+	}
+}
+
+func TestOTLPReceiverHTTP(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	tests := []struct {
+		name        string
+		contentType string
+		marshaler   pdata.TracesMarshaler
+		status      int
+	}{
+		{"protobuf", contentTypeProtobuf, otlp.NewProtobufTracesMarshaler(), http.StatusOK},
+		{"json", contentTypeJSON, otlp.NewJSONTracesMarshaler(), http.StatusOK},
+		{"unsupported", "text/plain", otlp.NewJSONTracesMarshaler(), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := NewTraceWindow(time.Minute, 100)
+			server := httptest.NewServer(NewOTLPReceiver(window))
+			defer server.Close()
+
+			body, err := tt.marshaler.MarshalTraces(buildOTLPTraces(start))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.Post(server.URL+otlpTracesPath, tt.contentType, bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status is %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			traceIDs, err := window.QueryTimeRange(NewQuery("post-storage-service", start, start.Add(time.Second), 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != http.StatusOK {
+				if len(traceIDs) != 0 {
+					t.Errorf("rejected spans were kept: %v", traceIDs)
+				}
+				return
+			}
+			traces, _ := window.GetTraces(traceIDs)
+			if len(traces) != 1 || len(traces[0].Spans) != 2 {
+				t.Errorf("received traces are %v", traces)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package extractor
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultQueryTimeout = 10 * time.Second
+)
+
+// QueryServiceReader reads traces from jaeger-query through api_v2.QueryService,
+// so the controller doesn't have to run next to the badger directory.
+type QueryServiceReader struct {
+	conn    *grpc.ClientConn
+	client  api_v2.QueryServiceClient
+	timeout time.Duration
+
+	// FindTraces already returns whole traces, keep the latest settled ones for the GetTraces that follows
+	cache *traceCache
+}
+
+// gogoCodec marshals the messages of api_v2 with gogo/protobuf, the codec of grpc can not handle their
+// custom types, e.g. GetTraceRequest.TraceID
+type gogoCodec struct{}
+
+func (gogoCodec) Marshal(v interface{}) ([]byte, error) {
+	return gogoproto.Marshal(v.(gogoproto.Message))
+}
+
+func (gogoCodec) Unmarshal(data []byte, v interface{}) error {
+	return gogoproto.Unmarshal(data, v.(gogoproto.Message))
+}
+
+func (gogoCodec) Name() string {
+	return "proto"
+}
+
+func NewQueryServiceReader(address string) (*QueryServiceReader, error) {
+	return newQueryServiceReader(address)
+}
+
+func newQueryServiceReader(address string, options ...grpc.DialOption) (*QueryServiceReader, error) {
+	options = append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(gogoCodec{})),
+	}, options...)
+	conn, err := grpc.Dial(address, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryServiceReader{
+		conn:    conn,
+		client:  api_v2.NewQueryServiceClient(conn),
+		timeout: defaultQueryTimeout,
+		cache:   newTraceCache(defaultCacheSize),
+	}, nil
+}
+
+func (qr *QueryServiceReader) Close() {
+	qr.conn.Close()
+	qr.cache.purge()
+}
+
+// QueryTimeRange jaeger-query has no FindTraceIDs in api_v2, so FindTraces is used and
+// only the IDs are returned. Like TraceReader, a query without numTraces is not limited.
+func (qr *QueryServiceReader) QueryTimeRange(query *Query) ([]model.TraceID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qr.timeout)
+	defer cancel()
+
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	// jaeger-query has no unlimited search, ask for the deepest one
+	numTraces := query.numTraces
+	if numTraces <= 0 || numTraces > math.MaxInt32 {
+		numTraces = math.MaxInt32
+	}
+	stream, err := qr.client.FindTraces(ctx, &api_v2.FindTracesRequest{
+		Query: &api_v2.TraceQueryParameters{
+			ServiceName:   query.serviceName,
+			OperationName: query.operationName,
+			Tags:          query.tags,
+			StartTimeMin:  query.startTime,
+			StartTimeMax:  query.endTime,
+			DurationMin:   query.durationMin,
+			DurationMax:   query.durationMax,
+			SearchDepth:   int32(numTraces),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make(map[model.TraceID]*model.Trace)
+	traceIDs := make([]model.TraceID, 0)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range chunk.Spans {
+			span := &chunk.Spans[i]
+			trace, ok := traces[span.TraceID]
+			if !ok {
+				trace = &model.Trace{}
+				traces[span.TraceID] = trace
+				traceIDs = append(traceIDs, span.TraceID)
+			}
+			trace.Spans = append(trace.Spans, span)
+		}
+	}
+
+	qr.cacheSettled(traces)
+	return traceIDs, nil
+}
+
+// cacheSettled caches the traces whose spans have all been written by now, the latest started last
+// so they are the last evicted
+func (qr *QueryServiceReader) cacheSettled(traces map[model.TraceID]*model.Trace) {
+	settled := time.Now().Add(-defaultTraceSettle)
+	startTimes := make(map[model.TraceID]time.Time, len(traces))
+	traceIDs := make([]model.TraceID, 0, len(traces))
+	for traceID, trace := range traces {
+		if latestEndTime(trace).Before(settled) {
+			startTimes[traceID] = earliestStartTime(trace)
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	sort.Slice(traceIDs, func(i, j int) bool {
+		return startTimes[traceIDs[i]].Before(startTimes[traceIDs[j]])
+	})
+	for _, traceID := range traceIDs {
+		qr.cache.add(traceID, traces[traceID])
+	}
+}
+
+func latestEndTime(trace *model.Trace) time.Time {
+	var latest time.Time
+	for _, span := range trace.Spans {
+		if end := span.StartTime.Add(span.Duration); end.After(latest) {
+			latest = end
+		}
+	}
+	return latest
+}
+
+// GetTraces returns the traces of traceIDs, those the last queries found are not fetched again.
+// The returned traces may be shared and must not be modified.
+func (qr *QueryServiceReader) GetTraces(traceIDs []model.TraceID) ([]*model.Trace, error) {
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		if trace, ok := qr.cache.get(traceID); ok {
+			traces = append(traces, trace)
+			continue
+		}
+		trace, err := qr.getTrace(traceID)
+		if err != nil {
+			return nil, err
+		}
+		if trace != nil {
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
+
+func (qr *QueryServiceReader) GetServices() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qr.timeout)
+	defer cancel()
+
+	response, err := qr.client.GetServices(ctx, &api_v2.GetServicesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	services := response.Services
+	sort.Strings(services)
+	return services, nil
+}
+
+func (qr *QueryServiceReader) GetOperations(service string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qr.timeout)
+	defer cancel()
+
+	response, err := qr.client.GetOperations(ctx, &api_v2.GetOperationsRequest{Service: service})
+	if err != nil {
+		return nil, err
+	}
+	// operations of different span kinds share names
+	seen := make(map[string]struct{})
+	operations := make([]string, 0, len(response.Operations))
+	for _, operation := range response.Operations {
+		if _, ok := seen[operation.Name]; !ok {
+			seen[operation.Name] = struct{}{}
+			operations = append(operations, operation.Name)
+		}
+	}
+	sort.Strings(operations)
+	return operations, nil
+}
+
+func (qr *QueryServiceReader) getTrace(traceID model.TraceID) (*model.Trace, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qr.timeout)
+	defer cancel()
+
+	stream, err := qr.client.GetTrace(ctx, &api_v2.GetTraceRequest{TraceID: traceID})
+	if err != nil {
+		return nil, err
+	}
+
+	spans := make([]*model.Span, 0, 32)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range chunk.Spans {
+			spans = append(spans, &chunk.Spans[i])
+		}
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	return &model.Trace{Spans: spans}, nil
+}
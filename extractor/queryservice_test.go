@@ -0,0 +1,365 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeQueryService serves traces like jaeger-query, every span in its own chunk
+type fakeQueryService struct {
+	api_v2.UnimplementedQueryServiceServer
+	traces []*model.Trace
+	// returned instead of the traces if set
+	findErr error
+	getErr  error
+
+	mu         sync.Mutex
+	lastFind   *api_v2.TraceQueryParameters
+	traceCalls int
+}
+
+func (s *fakeQueryService) FindTraces(req *api_v2.FindTracesRequest, stream api_v2.QueryService_FindTracesServer) error {
+	s.mu.Lock()
+	s.lastFind = req.Query
+	s.mu.Unlock()
+	if s.findErr != nil {
+		return s.findErr
+	}
+
+	found := 0
+	for _, trace := range s.traces {
+		if found == int(req.Query.SearchDepth) {
+			break
+		}
+		root := rootSpan(trace)
+		if root.StartTime.Before(req.Query.StartTimeMin) || root.StartTime.After(req.Query.StartTimeMax) {
+			continue
+		}
+		if req.Query.ServiceName != "" && !hasSpanOf(trace, req.Query.ServiceName, req.Query.OperationName) {
+			continue
+		}
+		found++
+		for _, span := range trace.Spans {
+			if err := stream.Send(&api_v2.SpansResponseChunk{Spans: []model.Span{*span}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeQueryService) GetTrace(req *api_v2.GetTraceRequest, stream api_v2.QueryService_GetTraceServer) error {
+	s.mu.Lock()
+	s.traceCalls++
+	s.mu.Unlock()
+	if s.getErr != nil {
+		return s.getErr
+	}
+
+	for _, trace := range s.traces {
+		if trace.Spans[0].TraceID != req.TraceID {
+			continue
+		}
+		spans := make([]model.Span, 0, len(trace.Spans))
+		for _, span := range trace.Spans {
+			spans = append(spans, *span)
+		}
+		return stream.Send(&api_v2.SpansResponseChunk{Spans: spans})
+	}
+	return status.Error(codes.NotFound, "trace not found")
+}
+
+func (s *fakeQueryService) getTraceCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.traceCalls
+}
+
+func hasSpanOf(trace *model.Trace, service, operation string) bool {
+	for _, span := range trace.Spans {
+		if span.Process.ServiceName == service && (operation == "" || span.OperationName == operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// newFakeQueryServiceReader serves srv in process and returns a reader connected to it
+func newFakeQueryServiceReader(t *testing.T, srv *fakeQueryService) *QueryServiceReader {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ForceServerCodec(gogoCodec{}))
+	api_v2.RegisterQueryServiceServer(server, srv)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	qr, err := newQueryServiceReader("bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(qr.Close)
+	return qr
+}
+
+func TestQueryServiceReaderFindTraces(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	traces := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 50, Start: start, Duration: time.Second, Seed: 1})
+	end := start.Add(time.Second)
+
+	tests := []struct {
+		name      string
+		query     *Query
+		wantDepth int32
+		want      func(trace *model.Trace) bool
+	}{
+		{
+			name:      "service",
+			query:     NewQuery(fixtureEntryService, start, end, 0),
+			wantDepth: math.MaxInt32,
+			want:      func(*model.Trace) bool { return true },
+		},
+		{
+			name:      "operation",
+			query:     NewQuery(fixtureEntryService, start, end, 0).WithOperationName("/wrk2-api/post/compose"),
+			wantDepth: math.MaxInt32,
+			want: func(trace *model.Trace) bool {
+				return hasSpanOf(trace, fixtureEntryService, "/wrk2-api/post/compose")
+			},
+		},
+		{
+			name:      "time range",
+			query:     NewQuery(fixtureEntryService, start.Add(500*time.Millisecond), end, 0),
+			wantDepth: math.MaxInt32,
+			want: func(trace *model.Trace) bool {
+				return !rootSpan(trace).StartTime.Before(start.Add(500 * time.Millisecond))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &fakeQueryService{traces: traces}
+			qr := newFakeQueryServiceReader(t, srv)
+
+			traceIDs, err := qr.QueryTimeRange(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if srv.lastFind.SearchDepth != tt.wantDepth {
+				t.Errorf("search depth = %d, want %d", srv.lastFind.SearchDepth, tt.wantDepth)
+			}
+
+			want := make(map[model.TraceID]*model.Trace)
+			for _, trace := range traces {
+				if tt.want(trace) {
+					want[trace.Spans[0].TraceID] = trace
+				}
+			}
+			if len(traceIDs) != len(want) {
+				t.Fatalf("found %d traces, want %d", len(traceIDs), len(want))
+			}
+
+			// the traces come with FindTraces, GetTraces does not fetch them again
+			got, err := qr.GetTraces(traceIDs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls := srv.getTraceCalls(); calls != 0 {
+				t.Errorf("GetTrace called %d times, want 0", calls)
+			}
+			for i, trace := range got {
+				if trace.Spans[0].TraceID != traceIDs[i] {
+					t.Fatalf("trace %d is %s, want %s", i, trace.Spans[0].TraceID, traceIDs[i])
+				}
+				wantTrace, ok := want[traceIDs[i]]
+				if !ok {
+					t.Fatalf("trace %s does not match the query", traceIDs[i])
+				}
+				if len(trace.Spans) != len(wantTrace.Spans) {
+					t.Errorf("trace %s has %d spans, want %d", traceIDs[i], len(trace.Spans), len(wantTrace.Spans))
+				}
+			}
+		})
+	}
+}
+
+func TestQueryServiceReaderLimit(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	srv := &fakeQueryService{traces: GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 50, Start: start, Duration: time.Second, Seed: 1})}
+	qr := newFakeQueryServiceReader(t, srv)
+
+	traceIDs, err := qr.QueryTimeRange(NewQuery(fixtureEntryService, start, start.Add(time.Second), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.lastFind.SearchDepth != 10 {
+		t.Errorf("search depth = %d, want 10", srv.lastFind.SearchDepth)
+	}
+	if len(traceIDs) != 10 {
+		t.Errorf("found %d traces, want 10", len(traceIDs))
+	}
+}
+
+func TestQueryServiceReaderCacheBound(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	traces := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 20, Start: start, Duration: time.Second, Seed: 1})
+	// jaeger-query does not return the traces in start order
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(traces), func(i, j int) { traces[i], traces[j] = traces[j], traces[i] })
+	srv := &fakeQueryService{traces: traces}
+	qr := newFakeQueryServiceReader(t, srv)
+	qr.cache = newTraceCache(5)
+
+	traceIDs, err := qr.QueryTimeRange(NewQuery(fixtureEntryService, start, start.Add(time.Second), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traceIDs) != 20 {
+		t.Fatalf("found %d traces, want 20", len(traceIDs))
+	}
+
+	// only the 5 latest started are kept
+	sort.Slice(traces, func(i, j int) bool {
+		return rootSpan(traces[i]).StartTime.After(rootSpan(traces[j]).StartTime)
+	})
+	latest := make([]model.TraceID, 0, 5)
+	for _, trace := range traces[:5] {
+		latest = append(latest, trace.Spans[0].TraceID)
+	}
+	if _, err := qr.GetTraces(latest); err != nil {
+		t.Fatal(err)
+	}
+	if calls := srv.getTraceCalls(); calls != 0 {
+		t.Errorf("GetTrace called %d times for the latest traces, want 0", calls)
+	}
+
+	got, err := qr.GetTraces(traceIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 {
+		t.Errorf("got %d traces, want 20", len(got))
+	}
+	if calls := srv.getTraceCalls(); calls != 15 {
+		t.Errorf("GetTrace called %d times, want 15", calls)
+	}
+}
+
+func TestQueryServiceReaderUnsettled(t *testing.T) {
+	// spans may still be written to a trace that just started
+	start := time.Now().Add(-time.Second)
+	srv := &fakeQueryService{traces: GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 3, Start: start, Duration: time.Millisecond, Seed: 1})}
+	qr := newFakeQueryServiceReader(t, srv)
+
+	traceIDs, err := qr.QueryTimeRange(NewQuery(fixtureEntryService, start, time.Now(), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traceIDs) != 3 {
+		t.Fatalf("found %d traces, want 3", len(traceIDs))
+	}
+	if _, err := qr.GetTraces(traceIDs); err != nil {
+		t.Fatal(err)
+	}
+	if calls := srv.getTraceCalls(); calls != 3 {
+		t.Errorf("GetTrace called %d times, want 3", calls)
+	}
+}
+
+func TestQueryServiceReaderGetTrace(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	traces := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 3, Start: start, Duration: time.Second, Seed: 1})
+	srv := &fakeQueryService{traces: traces}
+	qr := newFakeQueryServiceReader(t, srv)
+
+	missing := model.NewTraceID(1, 2)
+	got, err := qr.GetTraces([]model.TraceID{traces[0].Spans[0].TraceID, missing, traces[2].Spans[0].TraceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d traces, want 2, the missing one skipped", len(got))
+	}
+	for i, want := range []*model.Trace{traces[0], traces[2]} {
+		if got[i].Spans[0].TraceID != want.Spans[0].TraceID || len(got[i].Spans) != len(want.Spans) {
+			t.Errorf("trace %d is %s with %d spans, want %s with %d", i, got[i].Spans[0].TraceID, len(got[i].Spans),
+				want.Spans[0].TraceID, len(want.Spans))
+		}
+	}
+	if calls := srv.getTraceCalls(); calls != 3 {
+		t.Errorf("GetTrace called %d times, want 3", calls)
+	}
+}
+
+func TestQueryServiceReaderErrors(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	unavailable := status.Error(codes.Unavailable, "storage is down")
+
+	tests := []struct {
+		name     string
+		srv      *fakeQueryService
+		query    *Query
+		wantErr  error
+		wantCode codes.Code
+	}{
+		{
+			name:     "FindTraces",
+			srv:      &fakeQueryService{findErr: unavailable},
+			query:    NewQuery(fixtureEntryService, start, start.Add(time.Second), 0),
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:    "invalid query",
+			srv:     &fakeQueryService{},
+			query:   NewQuery(fixtureEntryService, start.Add(time.Second), start, 0),
+			wantErr: ErrStartTimeAfterEndTime,
+		},
+		{
+			name:    "operation without service",
+			srv:     &fakeQueryService{},
+			query:   NewQuery("", start, start.Add(time.Second), 0).WithOperationName("/wrk2-api/post/compose"),
+			wantErr: ErrServiceNameNotSet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qr := newFakeQueryServiceReader(t, tt.srv)
+			_, err := qr.QueryTimeRange(tt.query)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && status.Code(err) != tt.wantCode {
+				t.Errorf("error code = %v, want %v", status.Code(err), tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("GetTrace", func(t *testing.T) {
+		qr := newFakeQueryServiceReader(t, &fakeQueryService{getErr: unavailable})
+		traces, err := qr.GetTraces([]model.TraceID{model.NewTraceID(1, 2)})
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("error code = %v, want %v", status.Code(err), codes.Unavailable)
+		}
+		if traces != nil {
+			t.Errorf("got %d traces with the error", len(traces))
+		}
+	})
+}
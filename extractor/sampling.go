@@ -0,0 +1,136 @@
+package extractor
+
+import (
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	samplerTypeKey  = "sampler.type"
+	samplerParamKey = "sampler.param"
+
+	SamplerConst         = "const"
+	SamplerProbabilistic = "probabilistic"
+	SamplerRateLimiting  = "ratelimiting"
+	SamplerLowerBound    = "lowerbound"
+)
+
+// SamplingWeigher weights a trace by the inverse of the probability it was sampled with, read from the
+// sampler.type and sampler.param tags jaeger clients put on the root span
+type SamplingWeigher struct {
+	// requestRate returns the requests per second of an entry operation, 0 if unknown.
+	// Rate limited traces are only weighted if it is known
+	requestRate func(operation string) float64
+}
+
+func NewSamplingWeigher(requestRate func(operation string) float64) *SamplingWeigher {
+	return &SamplingWeigher{
+		requestRate: requestRate,
+	}
+}
+
+// Weight returns how many requests trace stands for, 1 if its sampling is unknown
+func (sw *SamplingWeigher) Weight(trace *model.Trace) float64 {
+	p := sw.Probability(trace)
+	if p <= 0 || p > 1 {
+		return 1
+	}
+	return 1 / p
+}
+
+// Probability returns the probability trace was sampled with, 1 if unknown
+func (sw *SamplingWeigher) Probability(trace *model.Trace) float64 {
+	span := samplerSpan(trace)
+	if span == nil {
+		return 1
+	}
+	samplerType, param := samplerTags(span)
+	switch samplerType {
+	case SamplerProbabilistic:
+		if param > 0 && param <= 1 {
+			return param
+		}
+	case SamplerRateLimiting, SamplerLowerBound:
+		if sw.requestRate == nil {
+			return 1
+		}
+		// param is the traces per second let through
+		if rate := sw.requestRate(span.OperationName); rate > param && param > 0 {
+			return param / rate
+		}
+	}
+	return 1
+}
+
+// samplerSpan returns the span which made the sampling decision: the root if it is tagged, the first tagged one otherwise
+func samplerSpan(trace *model.Trace) *model.Span {
+	var tagged *model.Span
+	for _, span := range trace.Spans {
+		if samplerType, _ := samplerTags(span); samplerType == "" {
+			continue
+		}
+		if len(span.References) == 0 {
+			return span
+		}
+		if tagged == nil {
+			tagged = span
+		}
+	}
+	return tagged
+}
+
+func samplerTags(span *model.Span) (string, float64) {
+	var samplerType string
+	var param float64
+	for _, kv := range span.Tags {
+		switch kv.Key {
+		case samplerTypeKey:
+			samplerType = kv.AsString()
+		case samplerParamKey:
+			switch kv.VType {
+			case model.Float64Type:
+				param = kv.Float64()
+			case model.Int64Type:
+				param = float64(kv.Int64())
+			case model.BoolType:
+				// const samplers report true
+				if kv.Bool() {
+					param = 1
+				}
+			}
+		}
+	}
+	return samplerType, param
+}
+
+// EffectiveSampleSize is the number of unweighted traces the weighted traces are as informative as
+func EffectiveSampleSize(traces []*model.Trace, options AnalysisOptions) float64 {
+	var sum, squared float64
+	for _, trace := range traces {
+		w := options.TraceWeight(trace)
+		sum += w
+		squared += w * w
+	}
+	if squared == 0 {
+		return 0
+	}
+	return sum * sum / squared
+}
+
+// EstimateRPS estimates the requests per second of each entry operation from the traces of a window,
+// each trace standing for as many requests as its weight
+func EstimateRPS(traces []*model.Trace, window time.Duration, options AnalysisOptions) map[string]float64 {
+	rps := make(map[string]float64)
+	if window <= 0 {
+		return rps
+	}
+	for _, trace := range traces {
+		root := rootSpan(trace)
+		if root == nil {
+			continue
+		}
+		rps[root.OperationName] += options.TraceWeight(trace) / window.Seconds()
+	}
+	return rps
+}
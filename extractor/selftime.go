@@ -0,0 +1,102 @@
+package extractor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// selfTime subtracts the union of the ChildOf children, clipped to the span, from its duration.
+// FollowsFrom children are not waited on, so their time still belongs to the span.
+func selfTime(sp *Span) time.Duration {
+	type interval struct {
+		start, end time.Duration
+	}
+	intervals := make([]interval, 0, len(sp.children))
+	for _, child := range sp.children {
+		start, end := child.startTime, spanEnd(child)
+		if start < sp.startTime {
+			start = sp.startTime
+		}
+		if end > spanEnd(sp) {
+			end = spanEnd(sp)
+		}
+		if end > start {
+			intervals = append(intervals, interval{start, end})
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start < intervals[j].start
+	})
+
+	var covered, coveredEnd time.Duration
+	for _, i := range intervals {
+		if i.start > coveredEnd {
+			coveredEnd = i.start
+		}
+		if i.end > coveredEnd {
+			covered += i.end - coveredEnd
+			coveredEnd = i.end
+		}
+	}
+	return sp.duration - covered
+}
+
+// walk visits every span of the graph below the root, FollowsFrom ones included
+func (g *Graph) walk(fn func(sp *Span)) {
+	if g.root == nil {
+		return
+	}
+	doPrint(g.root, 0, func(_ int, sp *Span) {
+		if !sp.synthetic {
+			fn(sp)
+		}
+	})
+}
+
+// GetSelfTimeByPod sums the self time of the spans of the trace by pod, leaving out the spans of unknown pods
+func (g *Graph) GetSelfTimeByPod() map[string]time.Duration {
+	selfTimes := make(map[string]time.Duration)
+	g.walk(func(sp *Span) {
+		if sp.podResolved {
+			selfTimes[sp.podName] += sp.selfTime
+		}
+	})
+	return selfTimes
+}
+
+// GetSelfTimeByService sums the self time of the spans of the trace by service
+func (g *Graph) GetSelfTimeByService() map[string]time.Duration {
+	selfTimes := make(map[string]time.Duration)
+	g.walk(func(sp *Span) {
+		selfTimes[sp.serviceName] += sp.selfTime
+	})
+	return selfTimes
+}
+
+// GetSelfTimeDistributionByPod sketches the self time of each pod in every trace it shows up in, weighted for sampling
+func GetSelfTimeDistributionByPod(traces []*model.Trace, options AnalysisOptions) map[string]*Sketch {
+	return selfTimeDistribution(traces, options, (*Graph).GetSelfTimeByPod)
+}
+
+// GetSelfTimeDistributionByService sketches the self time of each service in every trace it shows up in, weighted for sampling
+func GetSelfTimeDistributionByService(traces []*model.Trace, options AnalysisOptions) map[string]*Sketch {
+	return selfTimeDistribution(traces, options, (*Graph).GetSelfTimeByService)
+}
+
+func selfTimeDistribution(traces []*model.Trace, options AnalysisOptions, byKey func(g *Graph) map[string]time.Duration) map[string]*Sketch {
+	distributions := make(map[string]*Sketch)
+	for _, trace := range traces {
+		weight := options.TraceWeight(trace)
+		for key, selfTime := range byKey(NewGraph(trace, options)) {
+			sketch, ok := distributions[key]
+			if !ok {
+				sketch = NewSketch()
+				distributions[key] = sketch
+			}
+			sketch.AddWeighted(selfTime, weight)
+		}
+	}
+	return distributions
+}
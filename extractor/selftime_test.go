@@ -0,0 +1,89 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestSelfTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		// self time of span 1, in ms
+		want int
+	}{
+		{
+			name:  "no children",
+			trace: buildTestTrace(testSpan{1, "frontend", 0, 10, nil}),
+			want:  10,
+		},
+		{
+			name: "sequential children",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 5, 4, []model.SpanRef{childOf(1)}},
+			),
+			want: 3,
+		},
+		{
+			// the union of the children is subtracted once
+			name: "overlapping children",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "backend", 1, 4, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 3, 4, []model.SpanRef{childOf(1)}},
+				testSpan{4, "cache", 4, 1, []model.SpanRef{childOf(1)}},
+			),
+			want: 4,
+		},
+		{
+			// clock skew: only the parts inside the parent are subtracted
+			name: "children outside the parent",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 2, 10, nil},
+				testSpan{2, "backend", 0, 5, []model.SpanRef{childOf(1)}},
+				testSpan{3, "db", 10, 5, []model.SpanRef{childOf(1)}},
+				testSpan{4, "cache", 20, 5, []model.SpanRef{childOf(1)}},
+			),
+			want: 5,
+		},
+		{
+			name: "follower",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "queue", 2, 4, []model.SpanRef{followsFrom(1)}},
+			),
+			want: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph(tt.trace, AnalysisOptions{})
+			if got := findSpan(g, 1).GetSelfTime(); got != time.Duration(tt.want)*time.Millisecond {
+				t.Errorf("self time is %v, want %dms", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfTimeByPodAndService(t *testing.T) {
+	g := NewGraph(buildTestTrace(
+		testSpan{1, "frontend", 0, 10, nil},
+		testSpan{2, "backend", 1, 3, []model.SpanRef{childOf(1)}},
+		testSpan{3, "backend", 5, 4, []model.SpanRef{childOf(1)}},
+		testSpan{4, "", 9, 1, []model.SpanRef{childOf(1)}},
+	), AnalysisOptions{})
+
+	byPod := g.GetSelfTimeByPod()
+	if len(byPod) != 2 || byPod["frontend-0"] != 2*time.Millisecond || byPod["backend-0"] != 7*time.Millisecond {
+		t.Errorf("self times by pod are %v", byPod)
+	}
+	// the unresolved span still has a service
+	byService := g.GetSelfTimeByService()
+	if len(byService) != 3 || byService[""] != time.Millisecond {
+		t.Errorf("self times by service are %v", byService)
+	}
+}
@@ -0,0 +1,356 @@
+package extractor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ServiceNode is a service, or a pod, merged from the spans of many traces
+type ServiceNode struct {
+	Name string
+	// Service of a pod node, the name itself for a service node
+	Service  string
+	Spans    int
+	Latency  *Sketch
+	SelfTime *Sketch
+	// CriticalTime sums what the spans contributed to the critical paths of their traces
+	CriticalTime time.Duration
+}
+
+// ServiceEdge merges the calls from the spans of one service (or pod) to the spans of another
+type ServiceEdge struct {
+	From string
+	To   string
+	Kind EdgeKind
+	// Calls counts the child spans, Requests the traces with at least one of them
+	Calls    int
+	Requests int
+	// CriticalCalls counts the calls on the critical path of their trace
+	CriticalCalls int
+	// Multiplicity maps a number of calls in one request to the number of requests making that many
+	Multiplicity map[int]int
+	// Latency of the calls as seen by the callee
+	Latency *Sketch
+	// StartGap is the time the caller was free to make the call before the callee started: from the end of the
+	// call it made before, or from its start for its first call. EndGap is the time from the end of the callee to
+	// the next call of the caller, or to its end for the last call, and Gap their sum: transport and queueing
+	// overhead of the calls. Calls running in parallel are not waited on in between. FollowsFrom callers do not
+	// wait, only their StartGap is known
+	StartGap *Sketch
+	EndGap   *Sketch
+	Gap      *Sketch
+	// Skewed counts the calls with a negative gap, the clocks of the caller and the callee are off
+	Skewed int
+}
+
+// MeanMultiplicity is the average number of calls per request making any
+func (e *ServiceEdge) MeanMultiplicity() float64 {
+	if e.Requests == 0 {
+		return 0
+	}
+	return float64(e.Calls) / float64(e.Requests)
+}
+
+type serviceEdgeKey struct {
+	from, to string
+	kind     EdgeKind
+}
+
+type serviceLevel struct {
+	nodes map[string]*ServiceNode
+	edges map[serviceEdgeKey]*ServiceEdge
+}
+
+func newServiceLevel() *serviceLevel {
+	return &serviceLevel{
+		nodes: make(map[string]*ServiceNode),
+		edges: make(map[serviceEdgeKey]*ServiceEdge),
+	}
+}
+
+// ServiceGraph merges the traces of a window into service and pod level nodes and edges, kept as a whole and
+// per entry operation, i.e. the operation of the root span
+type ServiceGraph struct {
+	numTraces int
+	services  *serviceLevel
+	pods      *serviceLevel
+	entries   map[string]*serviceLevel
+	// number of traces of each entry operation
+	entryTraces map[string]int
+	// number of spans of each service whose pod could not be resolved
+	unresolved map[string]int
+	options    AnalysisOptions
+}
+
+func NewServiceGraph(options AnalysisOptions) *ServiceGraph {
+	return &ServiceGraph{
+		options:     options,
+		services:    newServiceLevel(),
+		pods:        newServiceLevel(),
+		entries:     make(map[string]*serviceLevel),
+		entryTraces: make(map[string]int),
+		unresolved:  make(map[string]int),
+	}
+}
+
+// BuildServiceGraph merges traces into a new ServiceGraph
+func BuildServiceGraph(traces []*model.Trace, options AnalysisOptions) *ServiceGraph {
+	sg := NewServiceGraph(options)
+	for _, trace := range traces {
+		sg.AddTrace(trace)
+	}
+	return sg
+}
+
+func (sg *ServiceGraph) AddTrace(trace *model.Trace) {
+	graph := NewGraph(trace, sg.options)
+	root := graph.GetRoot()
+	if root == nil {
+		return
+	}
+	sg.numTraces++
+
+	entryOp := root.operationName
+	if root.synthetic {
+		entryOp = root.children[0].operationName
+	}
+	sg.entryTraces[entryOp]++
+	entry, ok := sg.entries[entryOp]
+	if !ok {
+		entry = newServiceLevel()
+		sg.entries[entryOp] = entry
+	}
+
+	critical := graph.GetCriticalPath().contributionBySpan()
+	weight := sg.options.TraceWeight(trace)
+
+	serviceCalls := make(map[serviceEdgeKey]int)
+	podCalls := make(map[serviceEdgeKey]int)
+	graph.walk(func(sp *Span) {
+		for _, level := range []*serviceLevel{sg.services, entry} {
+			level.addNode(sp.serviceName, sp.serviceName, sp, critical, weight)
+		}
+		if sp.podResolved {
+			sg.pods.addNode(sp.podName, sp.serviceName, sp, critical, weight)
+		} else {
+			sg.unresolved[sp.serviceName]++
+		}
+
+		if sp.parent == nil || sp.parent.synthetic {
+			return
+		}
+		if sp.parent.serviceName != sp.serviceName {
+			key := serviceEdgeKey{sp.parent.serviceName, sp.serviceName, sp.kind}
+			serviceCalls[key]++
+			for _, level := range []*serviceLevel{sg.services, entry} {
+				level.addCall(key, sp, critical, weight)
+			}
+		}
+		if sp.podResolved && sp.parent.podResolved && sp.parent.podName != sp.podName {
+			key := serviceEdgeKey{sp.parent.podName, sp.podName, sp.kind}
+			podCalls[key]++
+			sg.pods.addCall(key, sp, critical, weight)
+		}
+	})
+
+	for key, calls := range serviceCalls {
+		for _, level := range []*serviceLevel{sg.services, entry} {
+			level.addRequest(key, calls)
+		}
+	}
+	for key, calls := range podCalls {
+		sg.pods.addRequest(key, calls)
+	}
+}
+
+func (l *serviceLevel) addNode(name, service string, sp *Span, critical map[*Span]time.Duration, weight float64) {
+	node, ok := l.nodes[name]
+	if !ok {
+		node = &ServiceNode{Name: name, Service: service, Latency: NewSketch(), SelfTime: NewSketch()}
+		l.nodes[name] = node
+	}
+	node.Spans++
+	node.Latency.AddWeighted(sp.duration, weight)
+	node.SelfTime.AddWeighted(sp.selfTime, weight)
+	node.CriticalTime += critical[sp]
+}
+
+func (l *serviceLevel) addCall(key serviceEdgeKey, sp *Span, critical map[*Span]time.Duration, weight float64) {
+	edge, ok := l.edges[key]
+	if !ok {
+		edge = &ServiceEdge{
+			From:         key.from,
+			To:           key.to,
+			Kind:         key.kind,
+			Multiplicity: make(map[int]int),
+			Latency:      NewSketch(),
+			StartGap:     NewSketch(),
+			EndGap:       NewSketch(),
+			Gap:          NewSketch(),
+		}
+		l.edges[key] = edge
+	}
+	edge.Calls++
+	edge.Latency.AddWeighted(sp.duration, weight)
+
+	from, to := callWindow(sp)
+	startGap := sp.startTime - from
+	edge.StartGap.AddWeighted(startGap, weight)
+	skewed := startGap < 0
+	if sp.kind == ChildOfEdge {
+		endGap := to - spanEnd(sp)
+		edge.EndGap.AddWeighted(endGap, weight)
+		edge.Gap.AddWeighted(startGap+endGap, weight)
+		skewed = skewed || endGap < 0
+	}
+	if skewed {
+		edge.Skewed++
+	}
+	if _, ok := critical[sp]; ok {
+		edge.CriticalCalls++
+	}
+}
+
+// callWindow returns when the parent of sp was done with its previous call, the latest end of its children before
+// sp started, and when it made its next one, the earliest start of its children after sp ended. The start and the
+// end of the parent bound them
+func callWindow(sp *Span) (time.Duration, time.Duration) {
+	from, to := sp.parent.startTime, spanEnd(sp.parent)
+	for _, sibling := range sp.parent.children {
+		if sibling == sp {
+			continue
+		}
+		if end := spanEnd(sibling); end <= sp.startTime && end > from {
+			from = end
+		}
+		if start := sibling.startTime; start >= spanEnd(sp) && start < to {
+			to = start
+		}
+	}
+	return from, to
+}
+
+func (l *serviceLevel) addRequest(key serviceEdgeKey, calls int) {
+	edge := l.edges[key]
+	edge.Requests++
+	edge.Multiplicity[calls]++
+}
+
+func (l *serviceLevel) sortedEdges() []*ServiceEdge {
+	edges := make([]*ServiceEdge, 0, len(l.edges))
+	for _, edge := range l.edges {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Calls != edges[j].Calls {
+			return edges[i].Calls > edges[j].Calls
+		}
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+func (l *serviceLevel) sortedNodes() []*ServiceNode {
+	nodes := make([]*ServiceNode, 0, len(l.nodes))
+	for _, node := range l.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+func (sg *ServiceGraph) NumTraces() int {
+	return sg.numTraces
+}
+
+// GetServices returns the service nodes sorted by name
+func (sg *ServiceGraph) GetServices() []*ServiceNode {
+	return sg.services.sortedNodes()
+}
+
+// GetPods returns the pod nodes sorted by name
+func (sg *ServiceGraph) GetPods() []*ServiceNode {
+	return sg.pods.sortedNodes()
+}
+
+// GetEdges returns the edges between services, most called first
+func (sg *ServiceGraph) GetEdges() []*ServiceEdge {
+	return sg.services.sortedEdges()
+}
+
+// GetPodEdges returns the edges between pods, most called first
+func (sg *ServiceGraph) GetPodEdges() []*ServiceEdge {
+	return sg.pods.sortedEdges()
+}
+
+// GetInboundGaps merges the gaps of the ChildOf calls to pod, and the latency of those calls as seen by pod
+func (sg *ServiceGraph) GetInboundGaps(pod string) (*Sketch, *Sketch) {
+	gaps, latency := NewSketch(), NewSketch()
+	for _, edge := range sg.pods.edges {
+		if edge.To == pod && edge.Kind == ChildOfEdge {
+			gaps.Merge(edge.Gap)
+			latency.Merge(edge.Latency)
+		}
+	}
+	return gaps, latency
+}
+
+// GetNetworkShare returns the part of the calls to pod spent outside of it at p50, gap / (gap + latency),
+// along with the effective number of calls it is estimated from
+func (sg *ServiceGraph) GetNetworkShare(pod string) (float64, float64) {
+	gaps, latency := sg.GetInboundGaps(pod)
+	gap50, latency50 := gaps.Quantile(0.5), latency.Quantile(0.5)
+	if gap50+latency50 <= 0 {
+		return 0, gaps.EffectiveSampleSize()
+	}
+	return float64(gap50) / float64(gap50+latency50), gaps.EffectiveSampleSize()
+}
+
+// GetUnresolvedSpans returns the number of spans of each service whose pod could not be resolved,
+// they are left out of the pod nodes and edges
+func (sg *ServiceGraph) GetUnresolvedSpans() map[string]int {
+	return sg.unresolved
+}
+
+// GetEntryOperations returns the operations of the root spans, sorted by name
+func (sg *ServiceGraph) GetEntryOperations() []string {
+	ops := make([]string, 0, len(sg.entries))
+	for op := range sg.entries {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// FanOut returns the edges between services of the requests entering through entryOp, most called first,
+// e.g. which services /wrk2-api/post/compose reaches and how many times per request
+func (sg *ServiceGraph) FanOut(entryOp string) []*ServiceEdge {
+	entry, ok := sg.entries[entryOp]
+	if !ok {
+		return nil
+	}
+	return entry.sortedEdges()
+}
+
+// NumRequests returns the number of traces entering through entryOp
+func (sg *ServiceGraph) NumRequests(entryOp string) int {
+	return sg.entryTraces[entryOp]
+}
+
+// Downstream returns the edges leaving service, most called first
+func (sg *ServiceGraph) Downstream(service string) []*ServiceEdge {
+	edges := make([]*ServiceEdge, 0)
+	for _, edge := range sg.services.sortedEdges() {
+		if edge.From == service {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
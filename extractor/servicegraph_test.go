@@ -0,0 +1,130 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func findServiceEdge(edges []*ServiceEdge, from, to string) *ServiceEdge {
+	for _, edge := range edges {
+		if edge.From == from && edge.To == to {
+			return edge
+		}
+	}
+	return nil
+}
+
+func TestServiceGraphGaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		to    string
+		// startGap and endGap of the call to to, in ms
+		startGap, endGap int
+	}{
+		{
+			// the second call starts after the first one returned, not after the caller started
+			name: "first of sequential calls",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "user", 1, 3, []model.SpanRef{childOf(1)}},
+				testSpan{3, "post", 6, 3, []model.SpanRef{childOf(1)}},
+			),
+			to:       "user",
+			startGap: 1,
+			endGap:   2,
+		},
+		{
+			name: "second of sequential calls",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "user", 1, 3, []model.SpanRef{childOf(1)}},
+				testSpan{3, "post", 6, 3, []model.SpanRef{childOf(1)}},
+			),
+			to:       "post",
+			startGap: 2,
+			endGap:   1,
+		},
+		{
+			// calls in parallel are not waited on
+			name: "parallel calls",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 10, nil},
+				testSpan{2, "user", 1, 6, []model.SpanRef{childOf(1)}},
+				testSpan{3, "post", 2, 6, []model.SpanRef{childOf(1)}},
+			),
+			to:       "post",
+			startGap: 2,
+			endGap:   2,
+		},
+		{
+			// the latest call returned before, the earliest started after
+			name: "between calls",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 0, 20, nil},
+				testSpan{2, "user", 1, 2, []model.SpanRef{childOf(1)}},
+				testSpan{3, "user", 2, 3, []model.SpanRef{childOf(1)}},
+				testSpan{4, "post", 7, 3, []model.SpanRef{childOf(1)}},
+				testSpan{5, "user", 14, 2, []model.SpanRef{childOf(1)}},
+				testSpan{6, "user", 12, 5, []model.SpanRef{childOf(1)}},
+			),
+			to:       "post",
+			startGap: 2,
+			endGap:   2,
+		},
+		{
+			name: "clock skew",
+			trace: buildTestTrace(
+				testSpan{1, "frontend", 2, 10, nil},
+				testSpan{2, "post", 0, 5, []model.SpanRef{childOf(1)}},
+			),
+			to:       "post",
+			startGap: -2,
+			endGap:   7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sg := BuildServiceGraph([]*model.Trace{tt.trace}, AnalysisOptions{})
+			for _, edge := range []*ServiceEdge{
+				findServiceEdge(sg.GetEdges(), "frontend", tt.to),
+				findServiceEdge(sg.GetPodEdges(), "frontend-0", tt.to+"-0"),
+			} {
+				if edge == nil {
+					t.Fatalf("no edge to %s", tt.to)
+				}
+				startGap, endGap := time.Duration(tt.startGap)*time.Millisecond, time.Duration(tt.endGap)*time.Millisecond
+				if got := edge.StartGap.Quantile(0.5); got != startGap {
+					t.Errorf("start gap to %s is %v, want %v", edge.To, got, startGap)
+				}
+				if got := edge.EndGap.Quantile(0.5); got != endGap {
+					t.Errorf("end gap to %s is %v, want %v", edge.To, got, endGap)
+				}
+				if skewed := tt.startGap < 0 || tt.endGap < 0; skewed != (edge.Skewed > 0) {
+					t.Errorf("%d skewed calls to %s", edge.Skewed, edge.To)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceGraphFollowerGap(t *testing.T) {
+	// the caller enqueues once it has the response of its call
+	sg := BuildServiceGraph([]*model.Trace{buildTestTrace(
+		testSpan{1, "frontend", 0, 10, nil},
+		testSpan{2, "user", 1, 3, []model.SpanRef{childOf(1)}},
+		testSpan{3, "queue", 5, 20, []model.SpanRef{followsFrom(1)}},
+	)}, AnalysisOptions{})
+	edge := findServiceEdge(sg.GetEdges(), "frontend", "queue")
+	if edge == nil || edge.Kind != FollowsFromEdge {
+		t.Fatalf("edge to the queue is %v", edge)
+	}
+	if got := edge.StartGap.Quantile(0.5); got != time.Millisecond {
+		t.Errorf("start gap is %v, want 1ms", got)
+	}
+	if edge.EndGap.Count() != 0 || edge.Gap.Count() != 0 {
+		t.Errorf("follower has %d end gaps", edge.EndGap.Count())
+	}
+}
@@ -0,0 +1,239 @@
+package extractor
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// defaultSketchAccuracy is the relative error of the quantiles of a Sketch
+	defaultSketchAccuracy = 0.01
+)
+
+// Sketch is a DDSketch of latencies: a value x is counted in bin ceil(log_gamma(x)), so any quantile is returned
+// within the relative accuracy, and sketches of different windows or pods merge by adding up their bins.
+// Values may be weighted, e.g. by the inverse of the probability their trace was sampled with
+type Sketch struct {
+	accuracy float64
+	gamma    float64
+	logGamma float64
+
+	// bins[i] is the weight of the values of key offset+i
+	bins   []float64
+	offset int
+	// values <= 0, latencies of clock-skewed spans may be negative
+	zeros float64
+
+	count int
+	// sum of the weights and of their squares
+	weight        float64
+	squaredWeight float64
+	sum           float64
+	min           time.Duration
+	max           time.Duration
+}
+
+type SketchBucket struct {
+	UpperBound time.Duration
+	Weight     float64
+}
+
+func NewSketch() *Sketch {
+	return NewSketchWithAccuracy(defaultSketchAccuracy)
+}
+
+// NewSketchWithAccuracy returns a Sketch whose quantiles are off by at most accuracy, relatively
+func NewSketchWithAccuracy(accuracy float64) *Sketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &Sketch{
+		accuracy: accuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+	}
+}
+
+// NewSketchOf returns a Sketch of latencies
+func NewSketchOf(latencies []time.Duration) *Sketch {
+	s := NewSketch()
+	for _, latency := range latencies {
+		s.Add(latency)
+	}
+	return s
+}
+
+func (s *Sketch) key(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / s.logGamma))
+}
+
+// value is the middle of bin key, in relative terms
+func (s *Sketch) value(key int) time.Duration {
+	return time.Duration(2 * math.Pow(s.gamma, float64(key)) / (s.gamma + 1))
+}
+
+func (s *Sketch) Add(d time.Duration) {
+	s.AddWeighted(d, 1)
+}
+
+// AddWeighted adds d as if it was seen weight times
+func (s *Sketch) AddWeighted(d time.Duration, weight float64) {
+	if weight <= 0 {
+		return
+	}
+	s.addN(d, weight)
+	if s.count == 0 || d < s.min {
+		s.min = d
+	}
+	if d > s.max {
+		s.max = d
+	}
+	s.count++
+	s.weight += weight
+	s.squaredWeight += weight * weight
+	s.sum += float64(d) * weight
+}
+
+func (s *Sketch) addN(d time.Duration, n float64) {
+	if d <= 0 {
+		s.zeros += n
+		return
+	}
+	s.addKey(s.key(d), n)
+}
+
+func (s *Sketch) addKey(key int, n float64) {
+	if len(s.bins) == 0 {
+		s.bins = make([]float64, 1)
+		s.offset = key
+	}
+	if key < s.offset {
+		bins := make([]float64, len(s.bins)+s.offset-key)
+		copy(bins[s.offset-key:], s.bins)
+		s.bins = bins
+		s.offset = key
+	}
+	if i := key - s.offset; i >= len(s.bins) {
+		bins := make([]float64, i+1)
+		copy(bins, s.bins)
+		s.bins = bins
+	}
+	s.bins[key-s.offset] += n
+}
+
+// Merge adds the values of other, which is left unchanged
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	for i, n := range other.bins {
+		if n == 0 {
+			continue
+		}
+		if other.gamma == s.gamma {
+			s.addKey(other.offset+i, n)
+		} else {
+			s.addN(other.value(other.offset+i), n)
+		}
+	}
+	s.zeros += other.zeros
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+	s.count += other.count
+	s.weight += other.weight
+	s.squaredWeight += other.squaredWeight
+	s.sum += other.sum
+}
+
+// Count returns the number of values added
+func (s *Sketch) Count() int {
+	return s.count
+}
+
+// Weight returns the sum of the weights of the values, the count if they are not weighted
+func (s *Sketch) Weight() float64 {
+	return s.weight
+}
+
+// EffectiveSampleSize is the number of unweighted values as informative as the weighted ones, (sum w)^2 / sum w^2
+func (s *Sketch) EffectiveSampleSize() float64 {
+	if s.squaredWeight == 0 {
+		return 0
+	}
+	return s.weight * s.weight / s.squaredWeight
+}
+
+func (s *Sketch) Mean() time.Duration {
+	if s.weight == 0 {
+		return 0
+	}
+	return time.Duration(s.sum / s.weight)
+}
+
+func (s *Sketch) Min() time.Duration {
+	return s.min
+}
+
+func (s *Sketch) Max() time.Duration {
+	return s.max
+}
+
+// Quantile returns the q-quantile, q in [0, 1]. It is 0 for an empty sketch, and exact at 0 and 1
+func (s *Sketch) Quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	// the rank of the unweighted values, scaled to their weight
+	rank := q * float64(s.count-1) * s.weight / float64(s.count)
+	seen := s.zeros
+	if rank < seen {
+		return s.min
+	}
+	for i, n := range s.bins {
+		seen += n
+		if rank < seen {
+			v := s.value(s.offset + i)
+			if v < s.min {
+				return s.min
+			}
+			if v > s.max {
+				return s.max
+			}
+			return v
+		}
+	}
+	return s.max
+}
+
+// Quantiles returns the quantiles of qs in the same order
+func (s *Sketch) Quantiles(qs []float64) []time.Duration {
+	results := make([]time.Duration, 0, len(qs))
+	for _, q := range qs {
+		results = append(results, s.Quantile(q))
+	}
+	return results
+}
+
+// Buckets returns the non-empty bins in ascending order, the values <= 0 are in a bucket of upper bound 0
+func (s *Sketch) Buckets() []SketchBucket {
+	buckets := make([]SketchBucket, 0)
+	if s.zeros > 0 {
+		buckets = append(buckets, SketchBucket{UpperBound: 0, Weight: s.zeros})
+	}
+	for i, n := range s.bins {
+		if n > 0 {
+			upper := time.Duration(math.Pow(s.gamma, float64(s.offset+i)))
+			buckets = append(buckets, SketchBucket{UpperBound: upper, Weight: n})
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,179 @@
+package extractor
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// exactQuantile is the value of sorted the quantiles of a Sketch approximate, at rank q*(n-1) rounded down
+func exactQuantile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func checkRelativeError(t *testing.T, name string, got, want time.Duration, accuracy float64) {
+	t.Helper()
+	if err := math.Abs(float64(got-want)) / float64(want); err > accuracy+1e-9 {
+		t.Errorf("%s = %v, want %v within %v, off by %v", name, got, want, accuracy, err)
+	}
+}
+
+func randomLatencies(rng *rand.Rand, n int) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		// log-normal around 10ms, with a long tail
+		latencies[i] = time.Duration(math.Exp(rng.NormFloat64()) * float64(10*time.Millisecond))
+	}
+	return latencies
+}
+
+func TestSketchEmpty(t *testing.T) {
+	s := NewSketch()
+	if s.Count() != 0 || s.Weight() != 0 || s.EffectiveSampleSize() != 0 || s.Mean() != 0 {
+		t.Errorf("empty sketch has count %d, weight %v and mean %v", s.Count(), s.Weight(), s.Mean())
+	}
+	for _, q := range []float64{0, 0.5, 0.99, 1} {
+		if got := s.Quantile(q); got != 0 {
+			t.Errorf("quantile %v of an empty sketch is %v", q, got)
+		}
+	}
+	if buckets := s.Buckets(); len(buckets) != 0 {
+		t.Errorf("empty sketch has buckets %v", buckets)
+	}
+	if e := s.Estimate(0.5, DefaultConfidence); e.Bounded || e.Meaningful(1) {
+		t.Errorf("estimate of an empty sketch is %+v", e)
+	}
+
+	s.Merge(NewSketch())
+	s.Merge(nil)
+	if s.Count() != 0 || len(s.Buckets()) != 0 {
+		t.Error("merging empty sketches added values")
+	}
+}
+
+func TestSketchSingleSample(t *testing.T) {
+	s := NewSketch()
+	s.Add(42 * time.Millisecond)
+	// the min and the max bound the bin, so every quantile is exact
+	for _, q := range []float64{0, 0.01, 0.5, 0.99, 1} {
+		if got := s.Quantile(q); got != 42*time.Millisecond {
+			t.Errorf("quantile %v = %v, want 42ms", q, got)
+		}
+	}
+	if s.Mean() != 42*time.Millisecond || s.Min() != 42*time.Millisecond || s.Max() != 42*time.Millisecond {
+		t.Errorf("mean %v, min %v and max %v, want 42ms", s.Mean(), s.Min(), s.Max())
+	}
+
+	zero := NewSketch()
+	zero.Add(-time.Millisecond)
+	if buckets := zero.Buckets(); len(buckets) != 1 || buckets[0].UpperBound != 0 || buckets[0].Weight != 1 {
+		t.Errorf("buckets of a negative latency are %v", buckets)
+	}
+	if got := zero.Quantile(0.5); got != -time.Millisecond {
+		t.Errorf("median of a negative latency is %v", got)
+	}
+}
+
+func TestSketchRelativeError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, accuracy := range []float64{0.01, 0.05} {
+		latencies := randomLatencies(rng, 10000)
+		s := NewSketchWithAccuracy(accuracy)
+		for _, latency := range latencies {
+			s.Add(latency)
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		for _, q := range []float64{0.001, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999} {
+			checkRelativeError(t, "quantile", s.Quantile(q), exactQuantile(latencies, q), accuracy)
+		}
+		if s.Quantile(0) != latencies[0] || s.Quantile(1) != latencies[len(latencies)-1] {
+			t.Errorf("extremes are %v and %v, want %v and %v", s.Quantile(0), s.Quantile(1), latencies[0], latencies[len(latencies)-1])
+		}
+		qs := []float64{0.5, 0.99}
+		if got := s.Quantiles(qs); got[0] != s.Quantile(0.5) || got[1] != s.Quantile(0.99) {
+			t.Errorf("quantiles are %v", got)
+		}
+	}
+}
+
+func TestSketchWeighted(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	latencies := randomLatencies(rng, 1000)
+
+	// a value weighted 3 is a value seen 3 times
+	weighted, repeated := NewSketch(), NewSketch()
+	for i, latency := range latencies {
+		weight := 1.0
+		if i%2 == 0 {
+			weight = 3
+		}
+		weighted.AddWeighted(latency, weight)
+		for j := 0; j < int(weight); j++ {
+			repeated.Add(latency)
+		}
+	}
+	weighted.AddWeighted(time.Hour, 0)
+	if weighted.Count() != 1000 || weighted.Weight() != 2000 {
+		t.Errorf("weighted sketch has count %d and weight %v, want 1000 and 2000", weighted.Count(), weighted.Weight())
+	}
+	if weighted.Max() == time.Hour {
+		t.Error("a value of weight 0 was added")
+	}
+	// (sum w)^2 / sum w^2 = 2000^2 / (500*9 + 500)
+	if got := weighted.EffectiveSampleSize(); math.Abs(got-800) > 1e-9 {
+		t.Errorf("effective sample size = %v, want 800", got)
+	}
+	if d := weighted.Mean() - repeated.Mean(); d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("mean = %v, want %v", weighted.Mean(), repeated.Mean())
+	}
+	// the rank is scaled from the count, so it may fall in the bin next to the one of the repeated values
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		checkRelativeError(t, "weighted quantile", weighted.Quantile(q), repeated.Quantile(q), 2*defaultSketchAccuracy)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	first, second := randomLatencies(rng, 3000), randomLatencies(rng, 5000)
+	all := append(append([]time.Duration{}, first...), second...)
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	merged, other := NewSketchOf(first), NewSketchOf(second)
+	merged.Merge(other)
+	whole := NewSketchOf(all)
+	if merged.Count() != len(all) || merged.Min() != all[0] || merged.Max() != all[len(all)-1] {
+		t.Errorf("merged sketch has count %d, min %v and max %v", merged.Count(), merged.Min(), merged.Max())
+	}
+	if other.Count() != len(second) {
+		t.Errorf("merged sketch was changed to count %d", other.Count())
+	}
+	// of the same accuracy, the bins add up exactly
+	if got, want := merged.Buckets(), whole.Buckets(); len(got) != len(want) {
+		t.Errorf("merged sketch has %d buckets, want %d", len(got), len(want))
+	} else {
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("bucket %d is %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		if merged.Quantile(q) != whole.Quantile(q) {
+			t.Errorf("merged quantile %v = %v, want %v", q, merged.Quantile(q), whole.Quantile(q))
+		}
+	}
+
+	// of another accuracy, the values are moved to the middle of their bins and lose that much
+	coarse := NewSketchWithAccuracy(0.05)
+	for _, latency := range second {
+		coarse.Add(latency)
+	}
+	mixed := NewSketchOf(first)
+	mixed.Merge(coarse)
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		checkRelativeError(t, "mixed quantile", mixed.Quantile(q), exactQuantile(all, q), 0.05+2*defaultSketchAccuracy)
+	}
+}
@@ -0,0 +1,106 @@
+package extractor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	defaultSnapshotRetries = 3
+	badgerManifest         = "MANIFEST"
+	badgerLock             = "LOCK"
+)
+
+// openSnapshot copies the store of p into a new directory under snapshotDir and opens the copy.
+// Jaeger may compact while we copy, so the copy is retried when it turns out inconsistent.
+func openSnapshot(p, snapshotDir string) (*badger.DB, string, error) {
+	var lastErr error
+	for i := 0; i < defaultSnapshotRetries; i++ {
+		dir, err := ioutil.TempDir(snapshotDir, "snapshot-")
+		if err != nil {
+			return nil, "", err
+		}
+		if err := snapshot(p, dir); err != nil {
+			lastErr = err
+			os.RemoveAll(dir)
+			continue
+		}
+		// the copy is ours, so it is opened for writing to let badger repair a half written tail
+		db, err := openStore(dir, false)
+		if err != nil {
+			lastErr = err
+			os.RemoveAll(dir)
+			continue
+		}
+		return db, dir, nil
+	}
+	return nil, "", fmt.Errorf("failed to snapshot badger store %s: %w", p, lastErr)
+}
+
+// snapshot copies the key and data directories of p into dst. The MANIFEST goes first, so all the
+// tables it refers to still exist afterwards unless a compaction deleted them in between.
+// Tables are immutable and only hard-linked, the files badger keeps appending to are copied.
+func snapshot(p, dst string) error {
+	for _, sub := range []string{"key", "data"} {
+		from := path.Join(p, sub)
+		to := path.Join(dst, sub)
+		if err := os.MkdirAll(to, 0755); err != nil {
+			return err
+		}
+
+		files, err := ioutil.ReadDir(from)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			if file.IsDir() || file.Name() == badgerLock {
+				continue
+			}
+			if file.Name() == badgerManifest {
+				names = append([]string{file.Name()}, names...)
+			} else {
+				names = append(names, file.Name())
+			}
+		}
+
+		for _, name := range names {
+			src := path.Join(from, name)
+			target := path.Join(to, name)
+			if strings.HasSuffix(name, ".sst") {
+				// fall back to copying across file systems
+				if err := os.Link(src, target); err == nil {
+					continue
+				}
+			}
+			if err := copyFile(src, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,72 @@
+package extractor
+
+import (
+	"sort"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// TraceSource is where the extractor gets its traces from. TraceReader reads Jaeger's
+// badger directory directly, QueryServiceReader talks to jaeger-query over gRPC.
+type TraceSource interface {
+	// QueryTimeRange returns the IDs of the traces matching query
+	QueryTimeRange(query *Query) ([]model.TraceID, error)
+	// GetTraces fetches the traces of traceIDs, traces not found are skipped
+	GetTraces(traceIDs []model.TraceID) ([]*model.Trace, error)
+	// GetServices lists the services having spans, sorted
+	GetServices() ([]string, error)
+	// GetOperations lists the operations of service, sorted
+	GetOperations(service string) ([]string, error)
+	Close()
+}
+
+var (
+	_ TraceSource = (*TraceReader)(nil)
+	_ TraceSource = (*QueryServiceReader)(nil)
+	_ TraceSource = (*TraceWindow)(nil)
+	_ TraceSource = (*FileReader)(nil)
+)
+
+// OperationKey names an operation of a service, operation names are only unique within their service
+type OperationKey struct {
+	Service   string
+	Operation string
+}
+
+// listOperations lists the operations of every service of traces
+func listOperations(traces []*model.Trace) map[string][]string {
+	seen := make(map[string]map[string]struct{})
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			if span.Process == nil {
+				continue
+			}
+			operations, ok := seen[span.Process.ServiceName]
+			if !ok {
+				operations = make(map[string]struct{})
+				seen[span.Process.ServiceName] = operations
+			}
+			operations[span.OperationName] = struct{}{}
+		}
+	}
+
+	results := make(map[string][]string, len(seen))
+	for service, operations := range seen {
+		names := make([]string, 0, len(operations))
+		for operation := range operations {
+			names = append(names, operation)
+		}
+		sort.Strings(names)
+		results[service] = names
+	}
+	return results
+}
+
+func listServices(traces []*model.Trace) []string {
+	services := make([]string, 0)
+	for service := range listOperations(traces) {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
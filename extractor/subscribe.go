@@ -0,0 +1,92 @@
+package extractor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	defaultPollInterval = 1 * time.Second
+	// spans of a trace are expected in the store at most this long after the trace started
+	defaultTraceSettle = 5 * time.Second
+)
+
+// Subscribe polls the store and delivers every trace started after the call exactly once.
+// A trace belongs to the poll whose time range holds its earliest span, and it is only read once
+// that range is defaultTraceSettle older than the store, so its spans have been written by then.
+// A snapshot is as old as its copy, so the ranges follow the snapshots rather than the clock.
+// The channel is closed when ctx is done.
+func (tr *TraceReader) Subscribe(ctx context.Context) <-chan *model.Trace {
+	ch := make(chan *model.Trace, defaultNumTraces)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(defaultPollInterval)
+		defer ticker.Stop()
+
+		cursor := tr.readTime()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deadline := tr.readTime().Add(-defaultTraceSettle)
+			if !deadline.After(cursor) {
+				continue
+			}
+			traces, err := tr.tail(cursor, deadline)
+			if err != nil {
+				log.Printf("failed to poll traces: %v", err)
+				continue
+			}
+			cursor = deadline.Add(time.Microsecond)
+
+			for _, trace := range traces {
+				select {
+				case ch <- trace:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// tail returns the traces whose earliest span started in [startTime, endTime]
+func (tr *TraceReader) tail(startTime, endTime time.Time) ([]*model.Trace, error) {
+	traceIDs, err := tr.QueryTimeRange(NewQuery("", startTime, endTime, 0))
+	if err != nil {
+		return nil, err
+	}
+	traces, err := tr.GetTraces(traceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	minTimeStamp := timeAsEpochMicroseconds(startTime)
+	results := make([]*model.Trace, 0, len(traces))
+	for _, trace := range traces {
+		// started in the previous range and has already been delivered
+		if timeAsEpochMicroseconds(earliestStartTime(trace)) < minTimeStamp {
+			continue
+		}
+		results = append(results, trace)
+	}
+	return results, nil
+}
+
+func earliestStartTime(trace *model.Trace) time.Time {
+	var earliest time.Time
+	for _, span := range trace.Spans {
+		if earliest.IsZero() || span.StartTime.Before(earliest) {
+			earliest = span.StartTime
+		}
+	}
+	return earliest
+}
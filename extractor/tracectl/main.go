@@ -0,0 +1,261 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+
+	"github.com/iwqos22-autoscale/code/extractor"
+)
+
+// Inspection of the jaeger badger store, opened read-only:
+//
+//	tracectl -store <dir> services
+//	tracectl -store <dir> -service <service> operations
+//	tracectl -store <dir> [-service <service> [-operation <operation>]] [-since <duration> | -start <time> -end <time>] traces
+//	tracectl -store <dir> [-dot <file>] [-json <file>] trace <trace-id>
+//	tracectl -store <dir> [-since <duration>] pods
+//	tracectl -store <dir> [-since <duration>] bottleneck
+//
+// pods and bottleneck look at the same traces as the controller by default, the latest 1000 of the last 5s.
+
+const (
+	defaultSince     = 5 * time.Second
+	defaultNumTraces = 1000
+	// pods printed of the tail latency attribution
+	numBlamedPods = 5
+)
+
+var (
+	storePath   string
+	snapshotDir string
+	service     string
+	operation   string
+	since       time.Duration
+	start       string
+	end         string
+	numTraces   int
+	dotFile     string
+	jsonFile    string
+	podTagKeys  string
+	// options of the analyses, built from the flags
+	options extractor.AnalysisOptions
+)
+
+func main() {
+	flag.StringVar(&storePath, "store", "", "directory of the badger store")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "read a snapshot of the store copied into this directory, while jaeger is running")
+	flag.StringVar(&service, "service", "", "only look at traces with spans of this service")
+	flag.StringVar(&operation, "operation", "", "only look at traces with spans of this operation of the service")
+	flag.DurationVar(&since, "since", defaultSince, "look at the traces started within this duration")
+	flag.StringVar(&start, "start", "", "look at the traces started after this RFC3339 time instead of -since")
+	flag.StringVar(&end, "end", "", "look at the traces started before this RFC3339 time, now if empty")
+	flag.IntVar(&numTraces, "limit", defaultNumTraces, "maximum number of traces, the latest ones, 0 for all")
+	flag.StringVar(&dotFile, "dot", "", "write the graph of the trace in Graphviz DOT to this file")
+	flag.StringVar(&jsonFile, "json", "", "write the graph of the trace in JSON to this file")
+	flag.StringVar(&podTagKeys, "pod-tag-keys", strings.Join(extractor.DefaultPodTagKeys, ","), "span tags identifying the pod, tried in order")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -store <dir> [flags] services | operations | traces | trace <trace-id> | pods | bottleneck\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if storePath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	options.PodIdentifier = extractor.NewPodIdentifier(strings.Split(podTagKeys, ","), nil)
+
+	reader, err := extractor.NewTraceReader(storePath, extractor.ReaderOptions{ReadOnly: true, SnapshotDir: snapshotDir})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer reader.Close()
+
+	switch flag.Arg(0) {
+	case "services":
+		err = printServices(reader)
+	case "operations":
+		err = printOperations(reader)
+	case "traces":
+		err = printTraceIDs(reader)
+	case "trace":
+		err = printTrace(reader, flag.Arg(1))
+	case "pods":
+		err = printPods(reader)
+	case "bottleneck":
+		err = printBottleneck(reader)
+	default:
+		flag.Usage()
+		reader.Close()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println(err)
+		reader.Close()
+		os.Exit(1)
+	}
+}
+
+func printServices(reader *extractor.TraceReader) error {
+	services, err := reader.GetServices()
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+func printOperations(reader *extractor.TraceReader) error {
+	if service == "" {
+		return fmt.Errorf("operations need -service")
+	}
+	operations, err := reader.GetOperations(service)
+	if err != nil {
+		return err
+	}
+	for _, op := range operations {
+		fmt.Println(op)
+	}
+	return nil
+}
+
+// query builds the query of the flags
+func query() (*extractor.Query, error) {
+	endTime := time.Now()
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, err
+		}
+		endTime = t
+	}
+	startTime := endTime.Add(-since)
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, err
+		}
+		startTime = t
+	}
+	return extractor.NewQuery(service, startTime, endTime, numTraces).WithOperationName(operation), nil
+}
+
+func queryTraceIDs(reader *extractor.TraceReader) ([]model.TraceID, error) {
+	q, err := query()
+	if err != nil {
+		return nil, err
+	}
+	return reader.QueryTimeRange(q)
+}
+
+func queryTraces(reader *extractor.TraceReader) ([]*model.Trace, error) {
+	traceIDs, err := queryTraceIDs(reader)
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetTraces(traceIDs)
+}
+
+// printTraceIDs prints the trace IDs from the latest to the oldest
+func printTraceIDs(reader *extractor.TraceReader) error {
+	traceIDs, err := queryTraceIDs(reader)
+	if err != nil {
+		return err
+	}
+	for _, traceID := range traceIDs {
+		fmt.Println(traceID)
+	}
+	return nil
+}
+
+func printTrace(reader *extractor.TraceReader, id string) error {
+	traceID, err := model.TraceIDFromString(id)
+	if err != nil {
+		return fmt.Errorf("invalid trace id %q: %w", id, err)
+	}
+	traces, err := reader.GetTraces([]model.TraceID{traceID})
+	if err != nil {
+		return err
+	}
+	if len(traces) == 0 {
+		return fmt.Errorf("trace %s not found", id)
+	}
+
+	graph := extractor.NewGraph(traces[0], options)
+	fmt.Println(graph.GetCompleteness())
+	graph.PrintGraph()
+	fmt.Println("critical path:")
+	graph.PrintCriticalPath()
+
+	for _, out := range []struct {
+		path  string
+		write func(w io.Writer) error
+	}{
+		{dotFile, graph.WriteDOT},
+		{jsonFile, graph.WriteJSON},
+	} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return err
+		}
+		if err := out.write(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printPods prints the latency and self time percentiles and the error rate of the spans of every pod
+func printPods(reader *extractor.TraceReader) error {
+	traces, err := queryTraces(reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("traces: %d\n", len(traces))
+	sg := extractor.BuildServiceGraph(traces, options)
+	errorRates := extractor.GetErrorRates(traces, options)
+	for _, pod := range sg.GetPods() {
+		fmt.Printf("%s\t%s\tspans: %d\tp50: %v\tp90: %v\tp99: %v\tself time p50: %v\tp99: %v\terrors: %.2f%%\n",
+			pod.Name, pod.Service, pod.Spans,
+			pod.Latency.Quantile(0.5), pod.Latency.Quantile(0.9), pod.Latency.Quantile(0.99),
+			pod.SelfTime.Quantile(0.5), pod.SelfTime.Quantile(0.99), errorRates.ByPod[pod.Name].Rate()*100)
+	}
+	for svc, unresolved := range sg.GetUnresolvedSpans() {
+		fmt.Printf("%s\tspans of unresolved pods: %d\n", svc, unresolved)
+	}
+	return nil
+}
+
+// printBottleneck prints the pod the controller would scale for the traces, and the pods slowing down the tail requests
+func printBottleneck(reader *extractor.TraceReader) error {
+	traces, err := queryTraces(reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("traces: %d\n", len(traces))
+	fmt.Printf("bottleneck pod: %s\n", extractor.ExtractBottleNeckPod(traces, options))
+	fmt.Printf("bottleneck pod by self time: %s\n", extractor.ExtractBottleNeckPodBySelfTime(traces, options))
+	for i, blame := range extractor.AttributeTailLatency(traces, extractor.DefaultTailQuantile, options) {
+		if i == numBlamedPods {
+			break
+		}
+		fmt.Printf("tail latency of %s\textra: %.3fms\ttail: %.3fms\tmedian: %.3fms\n", blame.Pod, blame.ExtraMs, blame.TailMs, blame.MedianMs)
+	}
+	return nil
+}
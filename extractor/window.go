@@ -0,0 +1,241 @@
+package extractor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	defaultWindowPruneInterval = 1 * time.Second
+)
+
+// TraceWindow keeps the spans of the last retention in memory and serves them as a TraceSource,
+// for receivers that get spans pushed instead of reading them from a store
+type TraceWindow struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	maxSpans  int
+	traces    map[model.TraceID]*windowTrace
+	// the traces by start time, the earliest first
+	order     []*windowTrace
+	numSpans  int
+	lastPrune time.Time
+}
+
+type windowTrace struct {
+	traceID   model.TraceID
+	trace     *model.Trace
+	startTime time.Time
+	// when the last span of the trace arrived
+	updated time.Time
+}
+
+func NewTraceWindow(retention time.Duration, maxSpans int) *TraceWindow {
+	return &TraceWindow{
+		retention: retention,
+		maxSpans:  maxSpans,
+		traces:    make(map[model.TraceID]*windowTrace),
+	}
+}
+
+func (w *TraceWindow) AddSpans(spans []*model.Span) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for _, span := range spans {
+		wt, ok := w.traces[span.TraceID]
+		if !ok {
+			wt = &windowTrace{
+				traceID:   span.TraceID,
+				trace:     &model.Trace{},
+				startTime: span.StartTime,
+			}
+			w.traces[span.TraceID] = wt
+			w.insert(wt)
+		} else if span.StartTime.Before(wt.startTime) {
+			// spans of a trace arrive out of order, its root often last
+			w.remove(wt)
+			wt.startTime = span.StartTime
+			w.insert(wt)
+		}
+		wt.trace.Spans = append(wt.trace.Spans, span)
+		wt.updated = now
+		w.numSpans++
+	}
+
+	if w.numSpans > w.maxSpans || now.Sub(w.lastPrune) > defaultWindowPruneInterval {
+		w.prune(now)
+	}
+}
+
+// insert puts wt in order after the traces started at the same time, most traces arrive last
+func (w *TraceWindow) insert(wt *windowTrace) {
+	i := sort.Search(len(w.order), func(i int) bool {
+		return w.order[i].startTime.After(wt.startTime)
+	})
+	w.order = append(w.order, nil)
+	copy(w.order[i+1:], w.order[i:])
+	w.order[i] = wt
+}
+
+func (w *TraceWindow) remove(wt *windowTrace) {
+	i := sort.Search(len(w.order), func(i int) bool {
+		return !w.order[i].startTime.Before(wt.startTime)
+	})
+	for ; i < len(w.order); i++ {
+		if w.order[i] == wt {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// prune drops the traces not updated within the retention, then the oldest traces until maxSpans fit
+func (w *TraceWindow) prune(now time.Time) {
+	w.lastPrune = now
+	kept := w.order[:0]
+	for _, wt := range w.order {
+		if now.Sub(wt.updated) > w.retention {
+			w.drop(wt)
+			continue
+		}
+		kept = append(kept, wt)
+	}
+	oldest := 0
+	for ; oldest < len(kept) && w.numSpans > w.maxSpans; oldest++ {
+		w.drop(kept[oldest])
+	}
+	kept = append(kept[:0], kept[oldest:]...)
+	// let the dropped traces be collected
+	for i := len(kept); i < len(w.order); i++ {
+		w.order[i] = nil
+	}
+	w.order = kept
+}
+
+func (w *TraceWindow) drop(wt *windowTrace) {
+	w.numSpans -= len(wt.trace.Spans)
+	delete(w.traces, wt.traceID)
+}
+
+// QueryTimeRange matches the way the badger indexes are intersected: every condition of query
+// has to hold for some span of the trace started inside the time range
+func (w *TraceWindow) QueryTimeRange(query *Query) ([]model.TraceID, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	// the latest first
+	traceIDs := make([]model.TraceID, 0)
+	for i := len(w.order) - 1; i >= 0; i-- {
+		if query.numTraces > 0 && len(traceIDs) == query.numTraces {
+			break
+		}
+		if matchTrace(w.order[i].trace, query) {
+			traceIDs = append(traceIDs, w.order[i].traceID)
+		}
+	}
+	return traceIDs, nil
+}
+
+func matchTrace(trace *model.Trace, query *Query) bool {
+	var serviceMatched, durationMatched bool
+	tagsMatched := make(map[string]struct{}, len(query.tags))
+	for _, span := range trace.Spans {
+		if span.StartTime.Before(query.startTime) || span.StartTime.After(query.endTime) {
+			continue
+		}
+		if query.durationMin == 0 && query.durationMax == 0 ||
+			span.Duration >= query.durationMin && (query.durationMax == 0 || span.Duration <= query.durationMax) {
+			durationMatched = true
+		}
+		if query.serviceName != "" && span.Process.ServiceName != query.serviceName {
+			continue
+		}
+		if query.operationName == "" || span.OperationName == query.operationName {
+			serviceMatched = true
+		}
+		for k, v := range query.tags {
+			if hasTag(span, k, v) {
+				tagsMatched[k] = struct{}{}
+			}
+		}
+	}
+	return serviceMatched && durationMatched && len(tagsMatched) == len(query.tags)
+}
+
+// hasTag looks for the tag where jaeger's badger writer indexes it from
+func hasTag(span *model.Span, key, value string) bool {
+	match := func(kvs []model.KeyValue) bool {
+		for _, kv := range kvs {
+			if kv.Key == key && kv.AsString() == value {
+				return true
+			}
+		}
+		return false
+	}
+	if match(span.Tags) || match(span.Process.Tags) {
+		return true
+	}
+	for _, log := range span.Logs {
+		if match(log.Fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *TraceWindow) GetTraces(traceIDs []model.TraceID) ([]*model.Trace, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		if wt, ok := w.traces[traceID]; ok {
+			// spans may still be appended to the trace, hand out a copy
+			spans := make([]*model.Span, len(wt.trace.Spans))
+			copy(spans, wt.trace.Spans)
+			traces = append(traces, &model.Trace{Spans: spans})
+		}
+	}
+	return traces, nil
+}
+
+func (w *TraceWindow) GetServices() ([]string, error) {
+	services := make([]string, 0)
+	for service := range w.listOperations() {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (w *TraceWindow) GetOperations(service string) ([]string, error) {
+	return w.listOperations()[service], nil
+}
+
+func (w *TraceWindow) listOperations() map[string][]string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	traces := make([]*model.Trace, 0, len(w.traces))
+	for _, wt := range w.traces {
+		traces = append(traces, wt.trace)
+	}
+	return listOperations(traces)
+}
+
+func (w *TraceWindow) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.traces = make(map[model.TraceID]*windowTrace)
+	w.order = nil
+	w.numSpans = 0
+}
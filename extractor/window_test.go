@@ -0,0 +1,152 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// windowSpans returns the spans of a trace of id started at start, a root of service and a child of backend
+func windowSpans(id uint64, service string, start time.Time) []*model.Span {
+	traceID := model.NewTraceID(0, id)
+	return []*model.Span{
+		{
+			TraceID:       traceID,
+			SpanID:        1,
+			OperationName: "GET /",
+			StartTime:     start,
+			Duration:      10 * time.Millisecond,
+			Process:       model.NewProcess(service, nil),
+		},
+		{
+			TraceID:       traceID,
+			SpanID:        2,
+			OperationName: "read",
+			References:    []model.SpanRef{model.NewChildOfRef(traceID, 1)},
+			StartTime:     start.Add(time.Millisecond),
+			Duration:      5 * time.Millisecond,
+			Process:       model.NewProcess("backend", nil),
+		},
+	}
+}
+
+func windowTraceIDs(t *testing.T, w *TraceWindow, query *Query) []uint64 {
+	t.Helper()
+	traceIDs, err := w.QueryTimeRange(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint64, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		ids = append(ids, traceID.Low)
+	}
+	return ids
+}
+
+func TestTraceWindowQuery(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	w := NewTraceWindow(time.Hour, 100)
+	for _, id := range []uint64{3, 1, 4, 2} {
+		w.AddSpans(windowSpans(id, "frontend", start.Add(time.Duration(id)*time.Second)))
+	}
+	w.AddSpans(windowSpans(5, "admin", start.Add(5*time.Second)))
+
+	tests := []struct {
+		name  string
+		query *Query
+		want  []uint64
+	}{
+		{"latest first", NewQuery("", start, start.Add(time.Hour), 0), []uint64{5, 4, 3, 2, 1}},
+		{"limit", NewQuery("", start, start.Add(time.Hour), 2), []uint64{5, 4}},
+		{"service", NewQuery("frontend", start, start.Add(time.Hour), 0), []uint64{4, 3, 2, 1}},
+		{"operation", NewQuery("backend", start, start.Add(time.Hour), 0).WithOperationName("read"), []uint64{5, 4, 3, 2, 1}},
+		{"time range", NewQuery("", start.Add(2*time.Second), start.Add(3*time.Second), 0), []uint64{3, 2}},
+		{"duration", NewQuery("", start, start.Add(time.Hour), 0).WithDuration(8*time.Millisecond, 0), []uint64{5, 4, 3, 2, 1}},
+		{"no match", NewQuery("", start, start.Add(time.Hour), 0).WithDuration(time.Second, 0), []uint64{}},
+	}
+	for _, tt := range tests {
+		if got := windowTraceIDs(t, w, tt.query); !equalIDs(got, tt.want) {
+			t.Errorf("%s: traces are %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a trace moves back when its earlier root arrives after its other spans
+	late := windowSpans(6, "frontend", start.Add(500*time.Millisecond))
+	late[1].StartTime = start.Add(10 * time.Second)
+	w.AddSpans(late[1:])
+	if got := windowTraceIDs(t, w, NewQuery("", start, start.Add(time.Hour), 0)); got[0] != 6 {
+		t.Errorf("traces are %v, want 6 the latest", got)
+	}
+	w.AddSpans(late[:1])
+	if got := windowTraceIDs(t, w, NewQuery("", start, start.Add(time.Hour), 0)); !equalIDs(got, []uint64{5, 4, 3, 2, 1, 6}) {
+		t.Errorf("traces are %v, want 6 the earliest", got)
+	}
+
+	traces, err := w.GetTraces([]model.TraceID{model.NewTraceID(0, 6), model.NewTraceID(0, 7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 || len(traces[0].Spans) != 2 {
+		t.Fatalf("traces are %v", traces)
+	}
+	// a copy, spans still arriving are not seen
+	w.AddSpans(windowSpans(6, "frontend", start)[1:])
+	if len(traces[0].Spans) != 2 {
+		t.Errorf("trace has %d spans", len(traces[0].Spans))
+	}
+
+	services, _ := w.GetServices()
+	if len(services) != 3 || services[0] != "admin" || services[1] != "backend" || services[2] != "frontend" {
+		t.Errorf("services are %v", services)
+	}
+	if operations, _ := w.GetOperations("backend"); len(operations) != 1 || operations[0] != "read" {
+		t.Errorf("operations of backend are %v", operations)
+	}
+
+	w.Close()
+	if got := windowTraceIDs(t, w, NewQuery("", start, start.Add(time.Hour), 0)); len(got) != 0 {
+		t.Errorf("closed window has traces %v", got)
+	}
+}
+
+func TestTraceWindowPrune(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	all := NewQuery("", start, start.Add(time.Hour), 0)
+
+	t.Run("max spans", func(t *testing.T) {
+		// room for 3 traces of 2 spans, the earliest started are dropped whenever they arrived
+		w := NewTraceWindow(time.Hour, 6)
+		for _, id := range []uint64{2, 4, 1, 3} {
+			w.AddSpans(windowSpans(id, "frontend", start.Add(time.Duration(id)*time.Second)))
+		}
+		if got := windowTraceIDs(t, w, all); !equalIDs(got, []uint64{4, 3, 2}) {
+			t.Errorf("traces are %v, want 4, 3, 2", got)
+		}
+		w.AddSpans(windowSpans(5, "frontend", start.Add(5*time.Second)))
+		w.AddSpans(windowSpans(6, "frontend", start.Add(6*time.Second)))
+		if got := windowTraceIDs(t, w, all); !equalIDs(got, []uint64{6, 5, 4}) {
+			t.Errorf("traces are %v, want 6, 5, 4", got)
+		}
+		if w.numSpans != 6 || len(w.traces) != 3 || len(w.order) != 3 {
+			t.Errorf("window holds %d spans of %d traces, %d in order", w.numSpans, len(w.traces), len(w.order))
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		w := NewTraceWindow(50*time.Millisecond, 100)
+		w.AddSpans(windowSpans(1, "frontend", start.Add(time.Second)))
+		w.AddSpans(windowSpans(2, "frontend", start.Add(2*time.Second)))
+		time.Sleep(100 * time.Millisecond)
+		// a trace updated within the retention is kept, even if it started earlier
+		w.AddSpans(windowSpans(1, "frontend", start.Add(time.Second))[1:])
+		w.lastPrune = time.Time{}
+		w.AddSpans(windowSpans(3, "frontend", start.Add(3*time.Second)))
+		if got := windowTraceIDs(t, w, all); !equalIDs(got, []uint64{3, 1}) {
+			t.Errorf("traces are %v, want 3, 1", got)
+		}
+		if w.numSpans != 5 || len(w.order) != 2 {
+			t.Errorf("window holds %d spans of %d traces", w.numSpans, len(w.order))
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package extractor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// SpanWriter stores spans with the keys jaeger's badger writer uses, see the layout at the top of extractor.go,
+// so a store can be filled for tests and benchmarks without running jaeger
+type SpanWriter struct {
+	store    *badger.DB
+	encoding byte
+	ttl      time.Duration
+}
+
+type WriterOptions struct {
+	// JSONEncoding stores the spans in JSON instead of protobuf, as jaeger did before 1.9
+	JSONEncoding bool
+	// TTL expires the spans like jaeger's --badger.span-store-ttl, 0 keeps them forever
+	TTL time.Duration
+}
+
+// NewSpanWriter opens the store at p for writing, creating it if needed
+func NewSpanWriter(p string, options WriterOptions) (*SpanWriter, error) {
+	db, err := openStore(p, false)
+	if err != nil {
+		return nil, err
+	}
+	w := &SpanWriter{
+		store:    db,
+		encoding: defaultEncoding,
+		ttl:      options.TTL,
+	}
+	if options.JSONEncoding {
+		w.encoding = jsonEncoding
+	}
+	return w, nil
+}
+
+// WriteSpan stores span and its index keys in one transaction, like jaeger does
+func (w *SpanWriter) WriteSpan(span *model.Span) error {
+	entries, err := w.spanEntries(span)
+	if err != nil {
+		return err
+	}
+	return w.store.Update(func(txn *badger.Txn) error {
+		for _, entry := range entries {
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteSpans stores spans in a write batch, much faster than a transaction per span
+func (w *SpanWriter) WriteSpans(spans []*model.Span) error {
+	wb := w.store.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, span := range spans {
+		entries, err := w.spanEntries(span)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := wb.SetEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return wb.Flush()
+}
+
+func (w *SpanWriter) WriteTraces(traces []*model.Trace) error {
+	spans := make([]*model.Span, 0)
+	for _, trace := range traces {
+		spans = append(spans, trace.Spans...)
+	}
+	return w.WriteSpans(spans)
+}
+
+func (w *SpanWriter) Close() {
+	w.store.Close()
+}
+
+// spanEntries returns the primary entry of span followed by its index entries
+func (w *SpanWriter) spanEntries(span *model.Span) ([]*badger.Entry, error) {
+	if span.Process == nil {
+		return nil, fmt.Errorf("span %s of trace %s has no process", span.SpanID, span.TraceID)
+	}
+	var expiresAt uint64
+	if w.ttl > 0 {
+		expiresAt = uint64(time.Now().Add(w.ttl).Unix())
+	}
+	startTime := model.TimeAsEpochMicroseconds(span.StartTime)
+
+	value, err := encodeValue(span, w.encoding)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*badger.Entry, 0, 4+len(span.Tags)+len(span.Process.Tags))
+	entries = append(entries, &badger.Entry{
+		Key:       createPrimaryKey(span.TraceID, startTime, span.SpanID),
+		Value:     value,
+		ExpiresAt: expiresAt,
+		UserMeta:  w.encoding,
+	})
+
+	index := func(prefix byte, value []byte) {
+		entries = append(entries, &badger.Entry{
+			Key:       createIndexKey(prefix, value, startTime, span.TraceID),
+			ExpiresAt: expiresAt,
+		})
+	}
+	serviceName := span.Process.ServiceName
+	index(serviceNameIndexKey, []byte(serviceName))
+	index(operationNameIndexKey, []byte(serviceName+span.OperationName))
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, model.DurationAsMicroseconds(span.Duration))
+	index(durationIndexKey, duration)
+	for _, kv := range span.Tags {
+		index(tagIndexKey, []byte(serviceName+kv.Key+kv.AsString()))
+	}
+	for _, kv := range span.Process.Tags {
+		index(tagIndexKey, []byte(serviceName+kv.Key+kv.AsString()))
+	}
+	for _, log := range span.Logs {
+		for _, kv := range log.Fields {
+			index(tagIndexKey, []byte(serviceName+kv.Key+kv.AsString()))
+		}
+	}
+	return entries, nil
+}
+
+func encodeValue(span *model.Span, encodeType byte) ([]byte, error) {
+	switch encodeType {
+	case jsonEncoding:
+		return json.Marshal(span)
+	case protoEncoding:
+		return span.Marshal()
+	default:
+		return nil, fmt.Errorf("unknown encoding type: %#02x", encodeType)
+	}
+}
+
+func createPrimaryKey(traceID model.TraceID, startTime uint64, spanID model.SpanID) []byte {
+	key := make([]byte, 1+sizeOfTraceID+8+8)
+	copy(key, createPrimaryKeySeekPrefix(traceID))
+	pos := 1 + sizeOfTraceID
+	binary.BigEndian.PutUint64(key[pos:], startTime)
+	pos += 8
+	binary.BigEndian.PutUint64(key[pos:], uint64(spanID))
+	return key
+}
+
+func createIndexKey(prefix byte, value []byte, startTime uint64, traceID model.TraceID) []byte {
+	key := make([]byte, 1+len(value)+8+sizeOfTraceID)
+	key[0] = (prefix & indexKeyRange) | spanKeyPrefix
+	pos := 1 + copy(key[1:], value)
+	binary.BigEndian.PutUint64(key[pos:], startTime)
+	pos += 8
+	binary.BigEndian.PutUint64(key[pos:], traceID.High)
+	pos += 8
+	binary.BigEndian.PutUint64(key[pos:], traceID.Low)
+	return key
+}
@@ -0,0 +1,248 @@
+package extractor
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestWrittenFixturesEndToEnd(t *testing.T) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	slowPod := FixturePodName("post-storage-service", 0)
+	// the pod is slow in a tenth of the requests, which makes its contribution to the critical path vary the most
+	normal := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 270, Start: start, Duration: 3 * time.Second, Seed: 1})
+	slow := GenerateSocialNetworkTraces(FixtureOptions{NumTraces: 30, Start: start, Duration: 3 * time.Second,
+		SlowPod: slowPod, SlowFactor: 20, Seed: 2})
+	fixtures := append(append([]*model.Trace{}, normal...), slow...)
+
+	byID := make(map[model.TraceID]*model.Trace, len(fixtures))
+	for _, trace := range fixtures {
+		byID[trace.Spans[0].TraceID] = trace
+	}
+
+	tests := []struct {
+		name    string
+		options WriterOptions
+	}{
+		{"proto", WriterOptions{}},
+		{"json", WriterOptions{JSONEncoding: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := NewSpanWriter(dir, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.WriteTraces(fixtures); err != nil {
+				t.Fatal(err)
+			}
+			writer.Close()
+			reader := openReader(t, dir)
+
+			checkQueryTimeRange(t, reader, fixtures, start)
+
+			traceIDs, err := reader.QueryTimeRange(NewQuery("", start, start.Add(time.Hour), 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			traces, err := reader.GetTraces(traceIDs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(traces) != len(fixtures) {
+				t.Fatalf("read %d traces, want %d", len(traces), len(fixtures))
+			}
+			for _, trace := range traces {
+				checkTrace(t, trace, byID[trace.Spans[0].TraceID])
+			}
+
+			if pod := ExtractBottleNeckPod(traces, AnalysisOptions{}); pod != slowPod {
+				t.Errorf("bottleneck pod = %s, want %s", pod, slowPod)
+			}
+
+			opNames := make([]string, 0, len(socialNetworkWorkload))
+			for _, w := range socialNetworkWorkload {
+				opNames = append(opNames, w.call.operation)
+			}
+			got := GetLatencySketchByOperation(traces, opNames, AnalysisOptions{})
+			want := GetLatencySketchByOperation(fixtures, opNames, AnalysisOptions{})
+			for _, opName := range opNames {
+				if got[opName].Count() == 0 {
+					t.Errorf("no latency of %s", opName)
+				}
+				if got[opName].Count() != want[opName].Count() {
+					t.Errorf("%d latencies of %s, want %d", got[opName].Count(), opName, want[opName].Count())
+				}
+				if got[opName].Quantile(0.99) != want[opName].Quantile(0.99) {
+					t.Errorf("p99 of %s = %v, want %v", opName, got[opName].Quantile(0.99), want[opName].Quantile(0.99))
+				}
+			}
+		})
+	}
+}
+
+// checkQueryTimeRange queries fixtures, spread evenly from start, by time range, service and operation
+func checkQueryTimeRange(t *testing.T, reader *TraceReader, fixtures []*model.Trace, start time.Time) {
+	t.Helper()
+	tests := []struct {
+		name  string
+		query *Query
+		want  func(trace *model.Trace) bool
+	}{
+		{
+			name:  "all",
+			query: NewQuery("", start, start.Add(time.Hour), 0),
+			want:  func(*model.Trace) bool { return true },
+		},
+		{
+			name:  "entry service",
+			query: NewQuery(fixtureEntryService, start, start.Add(time.Hour), 0),
+			want:  func(*model.Trace) bool { return true },
+		},
+		{
+			name:  "entry operation",
+			query: NewQuery(fixtureEntryService, start, start.Add(time.Hour), 0).WithOperationName("/wrk2-api/post/compose"),
+			want: func(trace *model.Trace) bool {
+				return rootSpan(trace).OperationName == "/wrk2-api/post/compose"
+			},
+		},
+		{
+			name:  "downstream service",
+			query: NewQuery("social-graph-service", start, start.Add(time.Hour), 0),
+			want: func(trace *model.Trace) bool {
+				return hasSpanOf(trace, "social-graph-service", "")
+			},
+		},
+		{
+			// a trace is found if any of its spans started in the range
+			name:  "one second",
+			query: NewQuery("", start.Add(time.Second), start.Add(2*time.Second), 0),
+			want: func(trace *model.Trace) bool {
+				min, max := timeAsEpochMicroseconds(start.Add(time.Second)), timeAsEpochMicroseconds(start.Add(2*time.Second))
+				for _, span := range trace.Spans {
+					if ts := timeAsEpochMicroseconds(span.StartTime); ts >= min && ts <= max {
+						return true
+					}
+				}
+				return false
+			},
+		},
+		{
+			name:  "limit",
+			query: NewQuery("", start, start.Add(time.Hour), 10),
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		traceIDs, err := reader.QueryTimeRange(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.want == nil {
+			if len(traceIDs) != tt.query.numTraces {
+				t.Errorf("%s: found %d traces, want %d", tt.name, len(traceIDs), tt.query.numTraces)
+			}
+			continue
+		}
+
+		found := make(map[model.TraceID]struct{}, len(traceIDs))
+		for _, traceID := range traceIDs {
+			found[traceID] = struct{}{}
+		}
+		want := 0
+		for _, trace := range fixtures {
+			if !tt.want(trace) {
+				continue
+			}
+			want++
+			if _, ok := found[trace.Spans[0].TraceID]; !ok {
+				t.Errorf("%s: trace %s not found", tt.name, trace.Spans[0].TraceID)
+			}
+		}
+		if len(traceIDs) != want {
+			t.Errorf("%s: found %d traces, want %d", tt.name, len(traceIDs), want)
+		}
+	}
+}
+
+// checkTrace compares trace read back from the store to the fixture it was written from, span by span and
+// as the call graph the fixture generated
+func checkTrace(t *testing.T, trace, fixture *model.Trace) {
+	t.Helper()
+	if fixture == nil {
+		t.Fatalf("trace %s was not written", trace.Spans[0].TraceID)
+	}
+	if len(trace.Spans) != len(fixture.Spans) {
+		t.Fatalf("trace %s has %d spans, want %d", fixture.Spans[0].TraceID, len(trace.Spans), len(fixture.Spans))
+	}
+	spans := make(map[model.SpanID]*model.Span, len(trace.Spans))
+	for _, span := range trace.Spans {
+		spans[span.SpanID] = span
+	}
+	for _, want := range fixture.Spans {
+		got, ok := spans[want.SpanID]
+		if !ok {
+			t.Fatalf("span %s of trace %s is missing", want.SpanID, want.TraceID)
+		}
+		if got.OperationName != want.OperationName || got.Process.ServiceName != want.Process.ServiceName ||
+			!got.StartTime.Equal(want.StartTime) || got.Duration != want.Duration ||
+			len(got.References) != len(want.References) || len(got.Tags) != len(want.Tags) {
+			t.Fatalf("span %s of trace %s is %v, want %v", want.SpanID, want.TraceID, got, want)
+		}
+	}
+
+	graph := NewGraph(trace, AnalysisOptions{})
+	completeness := graph.GetCompleteness()
+	if !completeness.Complete() || completeness.NumConnected != len(fixture.Spans) {
+		t.Errorf("trace %s is not a single tree: %s", fixture.Spans[0].TraceID, completeness)
+	}
+	root := graph.GetRoot()
+	if root == nil || root.GetServiceName() != fixtureEntryService || root.GetOperationName() != fixture.Spans[0].OperationName {
+		t.Fatalf("root of trace %s is %v, want %s:%s", fixture.Spans[0].TraceID, root, fixtureEntryService, fixture.Spans[0].OperationName)
+	}
+	if root.GetDuration() != fixture.Spans[0].Duration {
+		t.Errorf("root of trace %s lasts %v, want %v", fixture.Spans[0].TraceID, root.GetDuration(), fixture.Spans[0].Duration)
+	}
+	if got, want := graph.Signature(), fixtureSignature(fixture.Spans[0].OperationName); got != want {
+		t.Errorf("trace %s has call graph %s, want %s", fixture.Spans[0].TraceID, got, want)
+	}
+}
+
+// fixtureSignature is the Signature of the call graph of the entry operation in socialNetworkWorkload
+func fixtureSignature(operation string) string {
+	for _, w := range socialNetworkWorkload {
+		if w.call.operation == operation {
+			return callSignature(w.call, false)
+		}
+	}
+	return ""
+}
+
+func callSignature(call *fixtureCall, async bool) string {
+	label := call.service + ":" + call.operation
+	if async {
+		label = "~" + label
+	}
+	children := make(map[string]struct{})
+	for _, stage := range call.stages {
+		for _, c := range stage {
+			children[callSignature(c, false)] = struct{}{}
+		}
+	}
+	for _, c := range call.async {
+		children[callSignature(c, true)] = struct{}{}
+	}
+	if len(children) == 0 {
+		return label
+	}
+	signatures := make([]string, 0, len(children))
+	for signature := range children {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	return label + "(" + strings.Join(signatures, ",") + ")"
+}
@@ -19,6 +19,7 @@ require (
 	github.com/prometheus/client_golang v1.11.0
 	github.com/samuel/go-zookeeper v0.0.0-20190923202752-2cc03de413da // indirect
 	go.etcd.io/etcd v0.0.0-20191023171146-3cf2f69b5738 // indirect
+	go.opentelemetry.io/collector/model v0.38.0
 	go.uber.org/tools v0.0.0-20190618225709-2cfd321de3ee // indirect
 	google.golang.org/grpc v1.41.0
 	google.golang.org/protobuf v1.27.1
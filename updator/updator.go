@@ -6,7 +6,8 @@ import (
 	"fmt"
 	"github.com/jaegertracing/jaeger/model"
 	"path/filepath"
-	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
@@ -31,6 +32,16 @@ const (
 	defaultE2eLatency               = 1 * time.Second
 	defaultRPSThreshold     int64   = 1000
 	defaultIntervalScan             = 5 * time.Second
+	defaultIntervalSnapshot         = 10 * time.Second
+	defaultWindowRetention          = 1 * time.Minute
+	defaultWindowMaxSpans   int     = 1000000
+	defaultMinSamples       int     = 30
+	defaultNetworkShare     float64 = 0.3
+	defaultEntryService     string  = "nginx-web-server"
+	defaultMaxErrorRate     float64 = 0.05
+	defaultSettledTraces    int     = 10000
+
+	rpsQueryFormat = `rate(http_request_total{exported_endpoint="%s"}[2s])`
 )
 
 type policyKey struct {
@@ -69,9 +80,30 @@ type Updator struct {
 	history        map[string]*HistoryEntry
 	clientset      *kubernetes.Clientset
 	metricsMonitor *metrics.MetricsMonitor
-	traceReader    *extractor.TraceReader
+	traceReader    extractor.TraceSource
 	svcList        []string
 	svcPodsMap     map[string]*[]string
+	// error rates and percentiles are only acted on with at least minSamples traces, percentiles with a confidence
+	// interval at confidence too, see percentileMinSamples
+	minSamples int
+	confidence float64
+	// every operation of the entry services is held to the SLO
+	entryServices []string
+	maxErrorRate  float64
+
+	// request rates of the entry operations, to weight rate limited traces
+	rateMu       sync.Mutex
+	rates        map[string]float64
+	ratesQueried time.Time
+
+	// how pods are identified and traces weighted in the analyses
+	options    extractor.AnalysisOptions
+	classifier *extractor.RequestClassifier
+	// traces delivered by the subscription to the badger store since the last classification, nil without it
+	settledMu sync.Mutex
+	settled   []*model.Trace
+	// services whose self time departs from their recent past
+	anomalies *extractor.AnomalyDetector
 }
 
 func NewUpdator() *Updator {
@@ -82,6 +114,24 @@ func NewUpdator() *Updator {
 	} else {
 		kubeconfig = flag.String("kubeconfig", "", "")
 	}
+	// read traces from jaeger-query instead of the local badger directory if set
+	queryAddress := flag.String("jaeger-query", "", "address of the jaeger-query gRPC endpoint, e.g. jaeger-query:16685")
+	readOnly := flag.Bool("badger-readonly", false, "open the badger store read-only")
+	snapshotDir := flag.String("badger-snapshot-dir", "", "read a snapshot of the badger store copied into this directory")
+	snapshotInterval := flag.Duration("badger-snapshot-interval", defaultIntervalSnapshot, "how often the badger snapshot is refreshed")
+	fetchConcurrency := flag.Int("badger-fetch-concurrency", 0, "number of workers reading traces from badger, a default if 0")
+	cacheSize := flag.Int("badger-cache-size", 0, "number of decoded traces cached, a default if 0 and disabled if negative")
+	// receive OpenTelemetry spans ourselves instead of reading them from jaeger if either is set
+	otlpHTTPAddress := flag.String("otlp-http", "", "listen address of the OTLP/HTTP receiver, e.g. :4318")
+	otlpGRPCAddress := flag.String("otlp-grpc", "", "listen address of the OTLP/gRPC receiver, e.g. :4317")
+	minSamples := flag.Int("min-samples", defaultMinSamples, fmt.Sprintf("minimum number of traces to act on an error rate or a percentile from; "+
+		"a percentile needs at least as many as bound its interval at -confidence too, e.g. %d for p99 at %v",
+		extractor.MinSamples(0.99, extractor.DefaultConfidence), extractor.DefaultConfidence))
+	confidence := flag.Float64("confidence", extractor.DefaultConfidence, "confidence level of the percentile intervals")
+	podTagKeys := flag.String("pod-tag-keys", strings.Join(extractor.DefaultPodTagKeys, ","), "span tags identifying the pod, tried in order")
+	entryServices := flag.String("entry-services", defaultEntryService, "services receiving the requests, the SLO applies to all of their operations")
+	significance := flag.Float64("anomaly-significance", extractor.DefaultSignificance, "significance of the test of the self time of a service against its baseline")
+	maxErrorRate := flag.Float64("max-error-rate", defaultMaxErrorRate, "part of the requests of an operation allowed to fail")
 	flag.Parse()
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
@@ -95,21 +145,109 @@ func NewUpdator() *Updator {
 		panic(err)
 	}
 
+	// pods only known by their IP are looked up in the cluster
+	resolver := extractor.NewKubernetesPodResolver(clientset, defaultNamespace)
+
 	monitor := metrics.NewMetricsMonitor()
-	traceReader := extractor.NewTraceReader(defaultStorePath)
+	var traceReader extractor.TraceSource
+	var badgerReader *extractor.TraceReader
+	if *otlpHTTPAddress != "" || *otlpGRPCAddress != "" {
+		window := extractor.NewTraceWindow(defaultWindowRetention, defaultWindowMaxSpans)
+		receiver := extractor.NewOTLPReceiver(window)
+		if err := receiver.Start(*otlpHTTPAddress, *otlpGRPCAddress); err != nil {
+			panic(err)
+		}
+		traceReader = window
+	} else if *queryAddress != "" {
+		traceReader, err = extractor.NewQueryServiceReader(*queryAddress)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		badgerReader, err = extractor.NewTraceReader(defaultStorePath, extractor.ReaderOptions{
+			ReadOnly:         *readOnly,
+			SnapshotDir:      *snapshotDir,
+			SnapshotInterval: *snapshotInterval,
+			Concurrency:      *fetchConcurrency,
+			CacheSize:        *cacheSize,
+		})
+		if err != nil {
+			panic(err)
+		}
+		traceReader = badgerReader
+	}
 
-	return &Updator{
+	u := &Updator{
 		history:        make(map[string]*HistoryEntry),
 		clientset:      clientset,
 		metricsMonitor: monitor,
 		traceReader:    traceReader,
 		svcList:        []string{},
 		svcPodsMap:     make(map[string]*[]string, 0),
+		minSamples:     *minSamples,
+		confidence:     *confidence,
+		entryServices:  strings.Split(*entryServices, ","),
+		maxErrorRate:   *maxErrorRate,
+		rates:          make(map[string]float64),
+	}
+	u.options = extractor.AnalysisOptions{
+		PodIdentifier:   extractor.NewPodIdentifier(strings.Split(*podTagKeys, ","), resolver),
+		SamplingWeigher: extractor.NewSamplingWeigher(u.requestRate),
+	}
+	u.classifier = extractor.NewRequestClassifier(u.options)
+	u.anomalies = extractor.NewAnomalyDetector(extractor.DefaultBaselineWindows, *significance, *minSamples, u.options)
+	if badgerReader != nil {
+		u.settled = make([]*model.Trace, 0)
+		go u.collectSettledTraces(badgerReader.Subscribe(context.Background()))
+	}
+	return u
+}
+
+// collectSettledTraces keeps the traces of the subscription until the next classification, the latest
+// defaultSettledTraces of them if it is late
+func (u *Updator) collectSettledTraces(traces <-chan *model.Trace) {
+	for trace := range traces {
+		u.settledMu.Lock()
+		u.settled = append(u.settled, trace)
+		if len(u.settled) > defaultSettledTraces {
+			u.settled = append(u.settled[:0], u.settled[len(u.settled)-defaultSettledTraces:]...)
+		}
+		u.settledMu.Unlock()
 	}
 }
 
+// tracesToClassify returns the traces settled since the last call, every trace is classified once and with all of
+// its spans. Without subscription they are the recent traces, which may still miss spans.
+func (u *Updator) tracesToClassify(recent []*model.Trace) []*model.Trace {
+	u.settledMu.Lock()
+	defer u.settledMu.Unlock()
+	if u.settled == nil {
+		return recent
+	}
+	traces := u.settled
+	u.settled = make([]*model.Trace, 0, len(traces))
+	return traces
+}
+
+// requestRate returns the requests per second of opName from Prometheus, cached for defaultIntervalChecking
+func (u *Updator) requestRate(opName string) float64 {
+	u.rateMu.Lock()
+	defer u.rateMu.Unlock()
+
+	if time.Since(u.ratesQueried) > defaultIntervalChecking {
+		u.rates = make(map[string]float64)
+		u.ratesQueried = time.Now()
+	}
+	rate, ok := u.rates[opName]
+	if !ok {
+		rate = u.metricsMonitor.MetricsForTime(fmt.Sprintf(rpsQueryFormat, opName), time.Now())
+		u.rates[opName] = rate
+	}
+	return rate
+}
+
 // make sure: timeStart < timeEnd
-func (u *Updator) getQoS(svcName string, timeStart, timeEnd time.Time) (time.Duration, time.Duration) {
+func (u *Updator) getQoS(svcName string, timeStart, timeEnd time.Time) (extractor.QuantileEstimate, extractor.QuantileEstimate) {
 	// 注意这里用的是jaeger，用svcName来查，也即span.Process.ServiceName，而非k8s svc。
 	query := extractor.NewQuery(svcName, timeStart, timeEnd, defaultNumTraces)
 	tracesIDs, err := u.traceReader.QueryTimeRange(query)
@@ -122,18 +260,31 @@ func (u *Updator) getQoS(svcName string, timeStart, timeEnd time.Time) (time.Dur
 		panic(err)
 	}
 
-	lat50And99 := u.traceReader.GetPercentileLatency([]float64{0.5, 0.99}, traces,
+	sketch := extractor.GetLatencySketch(traces,
 		func(span *model.Span) bool {
 			return span.Process.ServiceName == svcName
-		})
-	return lat50And99[0], lat50And99[1]
+		}, u.options)
+	return sketch.Estimate(0.5, u.confidence), sketch.Estimate(0.99, u.confidence)
 }
 
-func (u *Updator) getQoSByOperation(svcName string, opNames []string, timeStart, timeEnd time.Time) map[string][]time.Duration {
-	query := extractor.NewQuery(svcName, timeStart, timeEnd, defaultNumTraces)
-	tracesIDs, err := u.traceReader.QueryTimeRange(query)
-	if err != nil {
-		panic(err)
+// getQoSByOperation sketches the latencies of the requests of each of opNames of svcName, and counts the failed ones
+func (u *Updator) getQoSByOperation(svcName string, opNames []string, timeStart, timeEnd time.Time) (map[string]*extractor.Sketch, map[extractor.OperationKey]*extractor.RequestErrorRate) {
+	// only fetch the traces of the operations we care about, once even if they have spans of several of them
+	tracesIDs := make([]model.TraceID, 0)
+	seen := make(map[model.TraceID]struct{})
+	for _, opName := range opNames {
+		query := extractor.NewQuery(svcName, timeStart, timeEnd, defaultNumTraces).WithOperationName(opName)
+		ids, err := u.traceReader.QueryTimeRange(query)
+		if err != nil {
+			panic(err)
+		}
+		for _, id := range ids {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			tracesIDs = append(tracesIDs, id)
+		}
 	}
 
 	traces, err := u.traceReader.GetTraces(tracesIDs)
@@ -141,8 +292,16 @@ func (u *Updator) getQoSByOperation(svcName string, opNames []string, timeStart,
 		panic(err)
 	}
 
-	lat50And99s := u.traceReader.GetPercentileLatencyByOperation([]float64{0.5, 0.99}, traces, opNames)
-	return lat50And99s
+	return extractor.GetLatencySketchByOperation(traces, opNames, u.options), extractor.GetRequestErrorRates(traces, u.options)
+}
+
+// percentileMinSamples is the number of traces the q-quantile is acted on from: minSamples, unless bounding its
+// confidence interval takes more
+func (u *Updator) percentileMinSamples(q float64) int {
+	if n := extractor.MinSamples(q, u.confidence); n > u.minSamples {
+		return n
+	}
+	return u.minSamples
 }
 
 func nodeNametoIP(nodeName string) string {
@@ -153,7 +312,24 @@ func getPolicy(bottleneck utils.ResourceType, rps int64) utils.ResourceType {
 	return policyMap[policyKey{bottleneck, rps > defaultRPSThreshold}]
 }
 
-func (u *Updator) update(podName string, rps int64) {
+// checkNetworkBottleneck reports when the share of the calls to podName spent between the caller and the pod
+// disagrees with the resource model on the network being the bottleneck. The gaps also hold the queueing and
+// serialization of the caller, so they are no ground to overrule it.
+func (u *Updator) checkNetworkBottleneck(podName string, bottleneck utils.ResourceType, sg *extractor.ServiceGraph) {
+	share, samples := sg.GetNetworkShare(podName)
+	if samples < float64(u.minSamples) {
+		return
+	}
+	networkBound := share >= defaultNetworkShare
+	if bottleneck == utils.ResourceNetworkBandwidth && !networkBound {
+		fmt.Printf("network is the bottleneck of %s, but gaps are only %.0f%% of its calls\n", podName, share*100)
+	}
+	if bottleneck != utils.ResourceNetworkBandwidth && networkBound {
+		fmt.Printf("gaps are %.0f%% of the calls of %s, but %s is the bottleneck\n", share*100, podName, bottleneck)
+	}
+}
+
+func (u *Updator) update(podName string, rps int64, sg *extractor.ServiceGraph) {
 	timeNow := time.Now()
 	lat50Before, lat99Before := u.getQoS(podName2SvcName(podName), timeNow.Add(-defaultIntervalBefore), timeNow)
 
@@ -173,6 +349,7 @@ func (u *Updator) update(podName string, rps int64) {
 	}
 
 	bottleneck := u.metricsMonitor.ExtractResourceType(podName, timeNow)
+	u.checkNetworkBottleneck(podName, bottleneck, sg)
 	policy := getPolicy(bottleneck, rps)
 
 	var latestShare int64
@@ -208,7 +385,15 @@ func (u *Updator) update(podName string, rps int64) {
 
 	timeNow = time.Now()
 	lat50After, lat99After := u.getQoS(podName2SvcName(podName), timeNow, timeNow.Add(defaultIntervalAfter))
-	u.history[podName].quality = (float64(lat99After) / float64(lat50After)) / (float64(lat99Before) / float64(lat50Before))
+	// a quality from a few traces would steer the next delta at random
+	for _, estimate := range []extractor.QuantileEstimate{lat50Before, lat99Before, lat50After, lat99After} {
+		if minSamples := u.percentileMinSamples(estimate.Quantile); !estimate.Meaningful(minSamples) {
+			fmt.Printf("not enough traces of %s to score the update: %d, %d needed for p%v\n",
+				podName, estimate.Samples, minSamples, estimate.Quantile*100)
+			return
+		}
+	}
+	u.history[podName].quality = (float64(lat99After.Value) / float64(lat50After.Value)) / (float64(lat99Before.Value) / float64(lat50Before.Value))
 }
 
 func (u *Updator) updateReplica(serviceName string, delta float64) int64 {
@@ -234,26 +419,72 @@ func podName2SvcName(podName string) string {
 	return podName[:len(podName)-17]
 }
 
+// entryOperations lists the operations of each entry service, new operations show up as they are traced
+func (u *Updator) entryOperations() map[string][]string {
+	operations := make(map[string][]string, len(u.entryServices))
+	for _, svcName := range u.entryServices {
+		opNames, err := u.traceReader.GetOperations(svcName)
+		if err != nil {
+			fmt.Printf("can not list operations of %s: %v\n", svcName, err)
+			continue
+		}
+		operations[svcName] = opNames
+	}
+	return operations
+}
+
 func (u *Updator) isQosViolation() (bool, string) {
 	timeNow := time.Now()
-	opNames := []string{"/wrk2-api/post/compose", "/wrk2-api/user-timeline/read", "/wrk2-api/home-timeline/read"}
-	lat50and99s := u.getQoSByOperation("nginx-web-server", opNames, timeNow.Add(-defaultIntervalChecking), timeNow)
+	// entry services may have operations of the same name
+	sketches := make(map[extractor.OperationKey]*extractor.Sketch)
+	errorRates := make(map[extractor.OperationKey]*extractor.RequestErrorRate)
+	for svcName, opNames := range u.entryOperations() {
+		opSketches, rates := u.getQoSByOperation(svcName, opNames, timeNow.Add(-defaultIntervalChecking), timeNow)
+		for op, sketch := range opSketches {
+			key := extractor.OperationKey{Service: svcName, Operation: op}
+			sketches[key] = sketch
+			errorRates[key] = rates[key]
+			if errorRates[key] == nil {
+				errorRates[key] = &extractor.RequestErrorRate{}
+			}
+		}
+	}
 
 	var violation bool
 	var operation string
 	var prevLat time.Duration
-	for op, lats := range lat50and99s {
-		lat50, lat99 := lats[0], lats[1]
-		violation = lat50 > defaultE2eLatency || float64(lat99)/float64(lat50) > defaultQoSThreshold
-		if violation && lat99 > prevLat {
-			prevLat = lat99
+	for key, sketch := range sketches {
+		op := key.Operation
+		// failures count even if the requests that made it are fast, or none took any time
+		failing := errorRates[key].Failing(u.maxErrorRate, u.confidence, u.minSamples)
+		if failing {
+			fmt.Printf("%.1f%% of the requests of %s failed, %.1f%% had a failed span\n",
+				errorRates[key].Rate()*100, op, errorRates[key].AnySpan.Rate()*100)
+		}
+		// only the operations of root spans have an end-to-end latency
+		if sketch.Count() == 0 && !failing {
+			continue
+		}
+		lat50, lat99 := sketch.Estimate(0.5, u.confidence), sketch.Estimate(0.99, u.confidence)
+		meaningful := lat50.Meaningful(u.percentileMinSamples(0.5)) && lat99.Meaningful(u.percentileMinSamples(0.99))
+		if !meaningful && !failing {
+			fmt.Printf("not enough traces of %s: %d, worth %.1f unweighted, %d needed\n",
+				op, lat99.Samples, lat99.EffectiveSamples, u.percentileMinSamples(0.99))
+			continue
+		}
+		// only violated if the whole confidence interval is
+		opViolation := failing || meaningful && (lat50.Lower > defaultE2eLatency || float64(lat99.Lower)/float64(lat50.Upper) > defaultQoSThreshold)
+		if opViolation && (!violation || lat99.Value > prevLat) {
+			violation = true
+			prevLat = lat99.Value
 			operation = op
 		}
 	}
 	return violation, operation
 }
 
-func (u *Updator) ExtractBottleNeckPod() string {
+// getRecentTraces returns the traces of the last defaultIntervalScan
+func (u *Updator) getRecentTraces() []*model.Trace {
 	t := time.Now()
 	query := extractor.NewQuery("", t.Add(-defaultIntervalScan), t, defaultNumTraces)
 	traceIDs, err := u.traceReader.QueryTimeRange(query)
@@ -265,55 +496,81 @@ func (u *Updator) ExtractBottleNeckPod() string {
 	if err != nil {
 		fmt.Println("can not get traces")
 	}
+	return traces
+}
 
-	pathSet := make(map[*extractor.Path]struct{}, 0)
-	for _, trace := range traces {
-		graph := extractor.NewGraph(trace)
-		path := graph.GetLongestPath()
-		if _, exists := pathSet[path]; exists {
-			continue
-		} else {
-			pathSet[path] = struct{}{}
-		}
+func (u *Updator) ExtractBottleNeckPod() string {
+	return extractor.ExtractBottleNeckPod(u.getRecentTraces(), u.options)
+}
+
+// ServiceGraph merges the traces of the last defaultIntervalScan into a service dependency graph
+func (u *Updator) ServiceGraph() *extractor.ServiceGraph {
+	return extractor.BuildServiceGraph(u.getRecentTraces(), u.options)
+}
+
+func printFanOut(sg *extractor.ServiceGraph, opName string) {
+	numRequests := sg.NumRequests(opName)
+	if numRequests == 0 {
+		return
 	}
+	fmt.Printf("fan-out of %s in %d requests:\n", opName, numRequests)
+	for _, edge := range sg.FanOut(opName) {
+		fmt.Printf("  %s -> %s: %.2f calls per request, p99 %v\n",
+			edge.From, edge.To, float64(edge.Calls)/float64(numRequests), edge.Latency.Quantile(0.99))
+	}
+}
 
-	bottlenecks := make(map[string][]time.Duration)
-	for p := range pathSet {
-		curr := p.GetHead()
-		for curr != nil {
-			span := curr.GetSpan()
-			pod := span.GetPodName()
-			duration := span.GetDuration()
-			bottlenecks[pod] = append(bottlenecks[pod], duration)
-			curr = curr.GetNext()
+// checkRequestClasses reports the call tree shapes not seen before, e.g. after a deploy changed the call pattern
+func (u *Updator) checkRequestClasses(traces []*model.Trace) {
+	for _, class := range u.classifier.Classify(traces) {
+		if class.New {
+			fmt.Printf("new request class %s of %s in %d traces: %s\n", class.ID, class.RootOperation, class.Count, class.Signature)
 		}
 	}
+}
 
-	var bottleneck string
-	max := 0.0
-	for pod, latencies := range bottlenecks {
-		sort.Slice(latencies, func(i, j int) bool {
-			return latencies[i] < latencies[j]
-		})
-		length := float64(len(latencies))
-		lat50 := latencies[int(length*0.5)]
-		lat99 := latencies[int(length*0.99)]
-		qos := float64(lat99) / float64(lat50)
-		if qos > max {
-			max = qos
-			bottleneck = pod
+// slowerServices tests the self times of the services in traces against their baseline and returns the ones
+// that got slower
+func (u *Updator) slowerServices(traces []*model.Trace) map[string]struct{} {
+	slower := make(map[string]struct{})
+	for _, anomaly := range u.anomalies.Observe(traces) {
+		if anomaly.Shift <= 0 {
+			continue
 		}
+		fmt.Printf("self time of %s is %v above its baseline, p-value: %.2g\n", anomaly.Service, anomaly.Shift, anomaly.PValue)
+		slower[anomaly.Service] = struct{}{}
 	}
-
-	return bottleneck
+	return slower
 }
 
 func (u *Updator) RunOnce() {
+	traces := u.getRecentTraces()
+	u.checkRequestClasses(u.tracesToClassify(traces))
+	// the baseline has to follow every window, not only the violating ones
+	slower := u.slowerServices(traces)
+
 	if violation, opName := u.isQosViolation(); violation {
-		rpsQuery := fmt.Sprintf(`rate(http_request_total{exported_endpoint="%s"}[2s])`, opName)
-		rps := u.metricsMonitor.MetricsForTime(rpsQuery, time.Now())
-		podName := u.ExtractBottleNeckPod()
-		go u.update(podName, int64(rps))
+		rps := u.metricsMonitor.MetricsForTime(fmt.Sprintf(rpsQueryFormat, opName), time.Now())
+		if rps == 0 {
+			// not exported, estimated from the traces weighted for sampling
+			rps = extractor.EstimateRPS(traces, defaultIntervalScan, u.options)[opName]
+		}
+		sg := extractor.BuildServiceGraph(traces, u.options)
+		printFanOut(sg, opName)
+		podName := extractor.ExtractBottleNeckPod(traces, u.options)
+		if blames := extractor.AttributeTailLatency(traces, extractor.DefaultTailQuantile, u.options); len(blames) > 0 {
+			fmt.Printf("%s adds %.3fms to the tail requests\n", blames[0].Pod, blames[0].ExtraMs)
+		}
+		if podName == "" {
+			fmt.Println("no bottleneck pod found, check -pod-tag-keys")
+			return
+		}
+		for _, pod := range sg.GetPods() {
+			if _, ok := slower[pod.Service]; pod.Name == podName && len(slower) > 0 && !ok {
+				fmt.Printf("%s is the bottleneck pod, but %s did not get slower\n", podName, pod.Service)
+			}
+		}
+		go u.update(podName, int64(rps), sg)
 	}
 }
 